@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/sources"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// backfillOptions are the flags of the `backfill` command
+type backfillOptions struct {
+	repoName   string
+	host       string
+	since      time.Time
+	until      time.Time
+	keepCursor bool
+	dryRun     bool
+}
+
+// parseBackfillOptions parses the arguments following `backfill`
+func parseBackfillOptions(args []string) (backfillOptions, error) {
+	var opts backfillOptions
+	var since, until string
+
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fs.StringVar(&opts.repoName, "repo", "", "name of the repository to backfill, e.g. owner/name")
+	fs.StringVar(&opts.host, "host", "", "host of the repository, only needed if several tracked repositories share its name")
+	fs.StringVar(&since, "since", "", "start of the window, as a date (2006-01-02) or RFC3339 time")
+	fs.StringVar(&until, "until", "", "end of the window (exclusive), as a date or RFC3339 time. Defaults to now")
+	fs.BoolVar(&opts.keepCursor, "keep-cursor", false, "do not move the cursor of the repository forward")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "log the matched and retracted issues without saving anything")
+	err := fs.Parse(args)
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.repoName == "" || since == "" {
+		return opts, errors.New("both --repo and --since are required")
+	}
+
+	opts.since, err = parseBackfillTime(since)
+	if err != nil {
+		return opts, fmt.Errorf("invalid --since: %v", err)
+	}
+	if until != "" {
+		opts.until, err = parseBackfillTime(until)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --until: %v", err)
+		}
+		if !opts.until.After(opts.since) {
+			return opts, errors.New("--until must be after --since")
+		}
+	}
+
+	return opts, nil
+}
+
+// parseBackfillTime parses either a date, at midnight UTC, or a RFC3339 time
+func parseBackfillTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// backfill runs the fetch-and-match pipeline of a repository for a past window. Unlike the regular
+// runs it matches the events again even if they were already processed, so that a window can be
+// replayed after fixing the matching. Cancelling the context stops it before anything is saved.
+func backfill(ctx context.Context, args []string) error {
+	opts, err := parseBackfillOptions(args)
+	if err != nil {
+		return fmt.Errorf("[backfill]: %v", err)
+	}
+
+	repository, err := findTrackedRepository(opts.repoName, opts.host)
+	if err != nil {
+		return fmt.Errorf("[backfill]: %v", err)
+	}
+	utils.LogInfo.Println("Backfilling repository:", repository.RepoName, "from:", opts.since, "until:", opts.until, "dry run:", opts.dryRun)
+
+	matcher, err := newIssueMatcher(repository)
+	if err != nil {
+		return fmt.Errorf("[backfill]: %v", err)
+	}
+
+	source, err := sources.For(repository)
+	if err != nil {
+		return fmt.Errorf("[backfill]: %v", err)
+	}
+
+	list, err := source.ListEvents(ctx, repository, sources.Cursor{Since: opts.since})
+	if err != nil {
+		return fmt.Errorf("[backfill]: %v", err)
+	}
+	utils.LogInfo.Println("Issue events for repository:", repository.RepoName, list.Stats)
+	if list.Truncated {
+		utils.LogError.Println("Issue events window got truncated for repository:", repository.RepoName, ". Events between:", opts.since, "and the oldest fetched event were not backfilled")
+	}
+
+	var issueEvents []events.Event
+	for _, e := range list.Events {
+		if opts.until.IsZero() || e.CreatedAt.Before(opts.until) {
+			issueEvents = append(issueEvents, e)
+		}
+	}
+	utils.LogInfo.Println("Matching", len(issueEvents), "issue events for repository:", repository.RepoName)
+
+	for _, e := range issueEvents {
+		matcher.match(e)
+	}
+
+	if opts.dryRun {
+		matcher.report()
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("[backfill]: %v", ctx.Err())
+	}
+
+	err = matcher.save()
+	if err != nil {
+		return fmt.Errorf("[backfill]: %v", err)
+	}
+
+	if opts.keepCursor || len(issueEvents) == 0 {
+		return nil
+	}
+
+	return moveCursorForward(repository, opts.since, issueEvents[len(issueEvents)-1])
+}
+
+// findTrackedRepository returns the tracked repository of the given name, on the given host if set
+func findTrackedRepository(repoName, host string) (services.Repository, error) {
+	repositories, err := services.GetAllRepositories()
+	if err != nil {
+		return services.Repository{}, fmt.Errorf("[findTrackedRepository]: %v", err)
+	}
+
+	var found []services.Repository
+	for _, repository := range repositories {
+		if repository.RepoName == repoName && (host == "" || repository.Host() == host) {
+			found = append(found, repository)
+		}
+	}
+
+	if len(found) == 0 {
+		return services.Repository{}, fmt.Errorf("repository %v is not tracked", repoName)
+	}
+	if len(found) > 1 {
+		return services.Repository{}, fmt.Errorf("repository %v is tracked on several hosts, pick one with --host", repoName)
+	}
+
+	return found[0], nil
+}
+
+// moveCursorForward moves the cursor of the repository to the given event, unless the regular runs
+// already processed it. The cursor is left alone if the backfilled window starts after it, as moving
+// it would skip the events in between.
+func moveCursorForward(repository services.Repository, since time.Time, lastEvent events.Event) error {
+	cursor, err := loadCursor(repository)
+	if err != nil {
+		return fmt.Errorf("[moveCursorForward]: %v", err)
+	}
+	if cursor.Processed(lastEvent) {
+		return nil
+	}
+	if since.After(cursor.Since) {
+		utils.LogError.Println("Not moving the cursor of repository:", repository.RepoName, ". The backfill started at:", since, "after the cursor:", cursor.Since, ", the events in between are left to the regular runs")
+		return nil
+	}
+
+	// The cached validators belong to the previous cursor and are left untouched
+	err = saveCursor(repository, sources.Cursor{Since: lastEvent.CreatedAt, EventID: lastEvent.ID})
+	if err != nil {
+		return fmt.Errorf("[moveCursorForward]: %v", err)
+	}
+	utils.LogInfo.Println("Updated cursor to:", lastEvent.CreatedAt, "event:", lastEvent.ID, "for repository:", repository.RepoName)
+
+	return nil
+}
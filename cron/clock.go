@@ -0,0 +1,22 @@
+package cron
+
+import "time"
+
+// Clock tells the time and waits for it to pass, so that schedules can run on a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the Clock of the system
+type RealClock struct{}
+
+// Now returns the current time
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse and then sends the current time on the returned channel
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
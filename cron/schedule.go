@@ -0,0 +1,221 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next time a job must run after the given time
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// field is the set of values a field of a cron expression matches
+type field uint64
+
+func (f field) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// bounds are the valid values of a field and the names they can be written with
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{min: 0, max: 59}
+	hours   = bounds{min: 0, max: 23}
+	days    = bounds{min: 1, max: 31}
+	months  = bounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	weekdays = bounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// descriptors are the shorthands of common expressions
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// SpecSchedule is the schedule of a standard 5 fields cron expression: minute, hour, day of month,
+// month and day of week. Times are matched in the location of the schedule.
+type SpecSchedule struct {
+	minute, hour, dom, month, dow field
+	// restrictedDays is set if both the day of month and the day of week are restricted, in which case
+	// a day matches if either of them does, as in the classic cron
+	restrictedDays bool
+	location       *time.Location
+}
+
+// EverySchedule runs a job at a fixed interval, written `@every 15m`
+type EverySchedule struct {
+	Interval time.Duration
+}
+
+// Next returns the given time plus the interval, rounded down to the second
+func (s EverySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.Interval).Truncate(time.Second)
+}
+
+// Parse parses a 5 fields cron expression, such as `*/15 * * * *` or `0 8 * * mon-fri`, one of the
+// descriptors such as `@daily`, or `@every <duration>`. Expressions are evaluated in UTC.
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if strings.HasPrefix(expr, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("invalid cron expression %q: interval must be at least 1s", expr)
+		}
+		return EverySchedule{Interval: interval}, nil
+	}
+
+	if descriptor, exists := descriptors[strings.ToLower(expr)]; exists {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields but got %v", expr, len(fields))
+	}
+
+	s := &SpecSchedule{location: time.UTC}
+	var err error
+	for i, f := range []struct {
+		dst *field
+		b   bounds
+	}{{&s.minute, minutes}, {&s.hour, hours}, {&s.dom, days}, {&s.month, months}, {&s.dow, weekdays}} {
+		*f.dst, err = parseField(fields[i], f.b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+	}
+
+	// Sunday may be written as 7
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+	s.restrictedDays = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// parseField parses a comma separated list of `*`, values and ranges, each with an optional `/step`
+func parseField(value string, b bounds) (field, error) {
+	var f field
+	for _, part := range strings.Split(value, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+
+		low, high := b.min, b.max
+		if rangePart != "*" {
+			ends := strings.SplitN(rangePart, "-", 2)
+			var err error
+			low, err = parseValue(ends[0], b)
+			if err != nil {
+				return 0, err
+			}
+			high = low
+			if len(ends) == 2 {
+				high, err = parseValue(ends[1], b)
+				if err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// `5/15` means every 15 starting at 5
+				high = b.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+
+		for v := low; v <= high; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+// parseValue parses a number or a name within the bounds
+func parseValue(value string, b bounds) (int, error) {
+	if v, exists := b.names[strings.ToLower(value)]; exists {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("value %v out of range [%v, %v]", v, b.min, b.max)
+	}
+
+	return v, nil
+}
+
+// Next returns the first matching minute after the given time, or the zero time if the expression
+// never matches, e.g. `0 0 30 2 *`
+func (s *SpecSchedule) Next(t time.Time) time.Time {
+	origLocation := t.Location()
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+
+	// Every valid expression matches within a few years, leap days included
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.hour.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t.In(origLocation)
+	}
+
+	return time.Time{}
+}
+
+func (s *SpecSchedule) dayMatches(t time.Time) bool {
+	domMatches := s.dom.has(t.Day())
+	dowMatches := s.dow.has(int(t.Weekday()))
+	if s.restrictedDays {
+		return domMatches || dowMatches
+	}
+
+	return domMatches && dowMatches
+}
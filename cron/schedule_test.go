@@ -0,0 +1,140 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"*/15 * * * *", false},
+		{"0 8 * * mon-fri", false},
+		{"0 0 1 jan,jul *", false},
+		{"5/15 * * * *", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{"@Hourly", false},
+		{"@every 90s", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"10-5 * * * *", true},
+		{"*/0 * * * *", true},
+		{"* * * * funday", true},
+		{"@every 500ms", true},
+		{"@every soon", true},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSpecScheduleNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want []string
+	}{
+		{
+			name: "steps from an offset",
+			expr: "5/15 * * * *",
+			from: "2021-01-01 10:00",
+			want: []string{"2021-01-01 10:05", "2021-01-01 10:20", "2021-01-01 10:35", "2021-01-01 10:50", "2021-01-01 11:05"},
+		},
+		{
+			name: "every 15 minutes",
+			expr: "*/15 * * * *",
+			from: "2021-01-01 10:14",
+			want: []string{"2021-01-01 10:15", "2021-01-01 10:30"},
+		},
+		{
+			// 2021-01-13 is a Wednesday, the 15th a Friday
+			name: "day of month or day of week when both are restricted",
+			expr: "0 0 13 * fri",
+			from: "2021-01-12 00:00",
+			want: []string{"2021-01-13 00:00", "2021-01-15 00:00", "2021-01-22 00:00", "2021-01-29 00:00", "2021-02-05 00:00", "2021-02-12 00:00", "2021-02-13 00:00"},
+		},
+		{
+			name: "day of month only",
+			expr: "0 0 13 * *",
+			from: "2021-01-12 00:00",
+			want: []string{"2021-01-13 00:00", "2021-02-13 00:00"},
+		},
+		{
+			// 2021-01-03 and 2021-01-10 are Sundays
+			name: "7 is Sunday",
+			expr: "30 6 * * 7",
+			from: "2021-01-01 00:00",
+			want: []string{"2021-01-03 06:30", "2021-01-10 06:30"},
+		},
+		{
+			name: "weekdays by name",
+			expr: "0 8 * * mon-fri",
+			from: "2021-01-01 09:00",
+			want: []string{"2021-01-04 08:00", "2021-01-05 08:00"},
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: "2021-01-01 00:00",
+			want: []string{"2024-02-29 00:00"},
+		},
+		{
+			name: "never",
+			expr: "0 0 30 2 *",
+			from: "2021-01-01 00:00",
+			want: []string{"0001-01-01 00:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+
+			next := date(tt.from)
+			for _, want := range tt.want {
+				next = schedule.Next(next)
+				if !next.Equal(date(want)) {
+					t.Fatalf("Next() = %v, want %v", next, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEveryScheduleNext(t *testing.T) {
+	schedule, err := Parse("@every 90s")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	from := date("2021-01-01 10:00").Add(500 * time.Millisecond)
+	if got, want := schedule.Next(from), date("2021-01-01 10:01").Add(30*time.Second); !got.Equal(want) {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+}
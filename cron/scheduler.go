@@ -0,0 +1,115 @@
+package cron
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// Job is a task run on a schedule
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context)
+}
+
+// Store persists when each job last ran, so that runs missed while the service was down are caught up
+type Store interface {
+	// LastRun returns the time of the last run of the job and whether it ever ran
+	LastRun(name string) (time.Time, bool, error)
+	SetLastRun(name string, t time.Time) error
+}
+
+// Scheduler runs each job on its own schedule. Runs of a job never overlap: the runs missed while the
+// previous one was still running, or while the service was down, are coalesced into a single run
+// as soon as possible.
+type Scheduler struct {
+	clock Clock
+	store Store
+	jobs  []Job
+}
+
+// NewScheduler returns a Scheduler of the given jobs. A nil store only catches up the runs missed
+// while the service is running.
+func NewScheduler(clock Clock, store Store, jobs ...Job) *Scheduler {
+	return &Scheduler{clock: clock, store: store, jobs: jobs}
+}
+
+// Run runs the jobs until the context is cancelled and the running jobs returned
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.runJob(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+// runJob runs the job every time it is due until the context is cancelled
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	last := s.lastRun(job)
+
+	for {
+		scheduledAt := job.Schedule.Next(last)
+		if scheduledAt.IsZero() {
+			utils.LogError.Println("Job:", job.Name, "is never due anymore")
+			return
+		}
+
+		if wait := scheduledAt.Sub(s.clock.Now()); wait > 0 {
+			utils.LogInfo.Println("Next run of job:", job.Name, "at:", scheduledAt)
+			select {
+			case <-s.clock.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		} else {
+			utils.LogInfo.Println("Catching up the run of job:", job.Name, "missed at:", scheduledAt)
+		}
+
+		startedAt := s.clock.Now()
+		utils.LogInfo.Println("Starting job:", job.Name)
+		job.Run(ctx)
+		utils.LogInfo.Println("Finished job:", job.Name, "in:", s.clock.Now().Sub(startedAt))
+
+		// A run interrupted by the shutdown may not have done all its work, it is caught up on the next start
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Every run due by the time this one started is covered by it
+		last = scheduledAt
+		for next := job.Schedule.Next(last); !next.IsZero() && !next.After(startedAt); next = job.Schedule.Next(next) {
+			last = next
+		}
+		if s.store != nil {
+			if err := s.store.SetLastRun(job.Name, last); err != nil {
+				utils.LogError.Println("Failed to save the last run of job:", job.Name, ". Error:", err)
+			}
+		}
+	}
+}
+
+// lastRun returns the time the job last ran, or now if it never ran so that it first runs at its
+// next scheduled time
+func (s *Scheduler) lastRun(job Job) time.Time {
+	if s.store == nil {
+		return s.clock.Now()
+	}
+
+	last, found, err := s.store.LastRun(job.Name)
+	if err != nil {
+		utils.LogError.Println("Failed to get the last run of job:", job.Name, ". Error:", err)
+		return s.clock.Now()
+	}
+	if !found {
+		return s.clock.Now()
+	}
+
+	return last
+}
@@ -0,0 +1,147 @@
+package cron
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// fakeClock jumps forward instead of waiting
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+
+	return ch
+}
+
+// memoryStore keeps the last runs in memory
+type memoryStore struct {
+	mu       sync.Mutex
+	lastRuns map[string]time.Time
+}
+
+func (s *memoryStore) LastRun(name string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, found := s.lastRuns[name]
+	return last, found, nil
+}
+
+func (s *memoryStore) SetLastRun(name string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastRuns[name] = t
+	return nil
+}
+
+func TestSchedulerRun(t *testing.T) {
+	utils.InitLogging("production")
+
+	tests := []struct {
+		name string
+		// lastRun is the stored last run, none if empty
+		lastRun string
+		now     string
+		// runs is the number of runs after which the scheduler gets shut down, during the last one
+		runs        int
+		wantRuns    []string
+		wantLastRun string
+	}{
+		{
+			name:        "missed runs are caught up in a single run",
+			lastRun:     "2021-01-01 10:00",
+			now:         "2021-01-01 11:07",
+			runs:        3,
+			wantRuns:    []string{"2021-01-01 11:07", "2021-01-01 11:15", "2021-01-01 11:30"},
+			wantLastRun: "2021-01-01 11:15",
+		},
+		{
+			name:        "run which is not due waits for its time",
+			lastRun:     "2021-01-01 10:00",
+			now:         "2021-01-01 10:07",
+			runs:        2,
+			wantRuns:    []string{"2021-01-01 10:15", "2021-01-01 10:30"},
+			wantLastRun: "2021-01-01 10:15",
+		},
+		{
+			name:     "job which never ran first runs at its next scheduled time",
+			now:      "2021-01-01 10:07",
+			runs:     2,
+			wantRuns: []string{"2021-01-01 10:15", "2021-01-01 10:30"},
+			// Set by the first run, the interrupted second one is not recorded
+			wantLastRun: "2021-01-01 10:15",
+		},
+		{
+			name:        "interrupted run is caught up on the next start",
+			lastRun:     "2021-01-01 10:00",
+			now:         "2021-01-01 10:20",
+			runs:        1,
+			wantRuns:    []string{"2021-01-01 10:20"},
+			wantLastRun: "2021-01-01 10:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: date(tt.now)}
+			store := &memoryStore{lastRuns: make(map[string]time.Time)}
+			if tt.lastRun != "" {
+				store.lastRuns["job"] = date(tt.lastRun)
+			}
+
+			schedule, err := Parse("*/15 * * * *")
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var runs []time.Time
+			job := Job{Name: "job", Schedule: schedule, Run: func(ctx context.Context) {
+				runs = append(runs, clock.Now())
+				if len(runs) == tt.runs {
+					cancel()
+				}
+				// Each run takes a few minutes
+				clock.After(3 * time.Minute)
+			}}
+			NewScheduler(clock, store, job).Run(ctx)
+
+			if len(runs) != len(tt.wantRuns) {
+				t.Fatalf("runs = %v, want %v", runs, tt.wantRuns)
+			}
+			for i, want := range tt.wantRuns {
+				if !runs[i].Equal(date(want)) {
+					t.Errorf("run %d at %v, want %v", i, runs[i], want)
+				}
+			}
+
+			last, found, _ := store.LastRun("job")
+			if !found || !last.Equal(date(tt.wantLastRun)) {
+				t.Errorf("last run = %v (found %v), want %v", last, found, tt.wantLastRun)
+			}
+		})
+	}
+}
@@ -29,3 +29,11 @@ func Init(environment, dbUser, dbPass, dbName, dbURL string) {
 	}
 	utils.LogInfo.Println("Successfully connected to the database")
 }
+
+// Executor runs queries on either the database or a transaction, so that models can take part in
+// a transaction spanning several of them
+type Executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
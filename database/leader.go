@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// LeaderLockKey is the key of the Postgres advisory lock held by the replica running the scheduler
+const LeaderLockKey int64 = 0x6973737565 // "issue"
+
+// RunAsLeader runs `run` only while this replica holds the leader lock, so that a single replica
+// runs it at a time. The lock is a session level advisory lock held on a dedicated connection:
+// Postgres releases it as soon as the connection of a leader which died is closed, and a standby
+// replica trying every `interval` takes over. If this replica loses its connection the context
+// given to `run` is cancelled and it goes back to standing by once `run` returned.
+//
+// RunAsLeader returns once ctx is cancelled and `run` returned.
+func RunAsLeader(ctx context.Context, key int64, interval time.Duration, run func(ctx context.Context)) {
+	for {
+		conn, acquired := tryLock(ctx, key)
+		if acquired {
+			utils.LogInfo.Println("Acquired leader lock, running as leader")
+			lead(ctx, conn, key, interval, run)
+			utils.LogInfo.Println("Stopped running as leader")
+		} else {
+			utils.LogInfo.Println("Another replica is the leader, standing by")
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tryLock tries to acquire the lock on a new connection, which is returned if it succeeded
+func tryLock(ctx context.Context, key int64) (*sql.Conn, bool) {
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		utils.LogError.Println("Failed to get a connection for the leader lock. Error:", err)
+		return nil, false
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired)
+	if err != nil {
+		utils.LogError.Println("Failed to try the leader lock. Error:", err)
+	}
+	if err != nil || !acquired {
+		conn.Close()
+		return nil, false
+	}
+
+	return conn, true
+}
+
+// lead runs `run` while checking every interval that the connection holding the lock is alive
+func lead(ctx context.Context, conn *sql.Conn, key int64, interval time.Duration, run func(ctx context.Context)) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := conn.ExecContext(ctx, `SELECT 1`); err != nil {
+				utils.LogError.Println("Lost the connection holding the leader lock. Error:", err)
+				cancel()
+				<-done
+				discard(conn)
+				return
+			}
+		case <-done:
+			unlock(conn, key)
+			return
+		case <-ctx.Done():
+			<-done
+			unlock(conn, key)
+			return
+		}
+	}
+}
+
+// unlock releases the lock so that a standby replica can take over right away
+func unlock(conn *sql.Conn, key int64) {
+	_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+	if err != nil {
+		utils.LogError.Println("Failed to release the leader lock. Error:", err)
+		discard(conn)
+		return
+	}
+	conn.Close()
+}
+
+// discard closes the connection for good instead of returning it to the pool, so that the lock is
+// released by Postgres along with the session if it is still alive
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// migrations holds the schema of the tables owned by this service. The rest of the tables
+// (GLOBAL_REPOSITORY, GITHUB_USER, NOTIFICATION_DATA, ...) are managed by issue-notifier-api.
+// Every statement must be idempotent as they are executed on each start.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS REPOSITORY_ETAG (
+		REPO_ID UUID PRIMARY KEY,
+		ETAG TEXT NOT NULL DEFAULT '',
+		LAST_MODIFIED TEXT NOT NULL DEFAULT '',
+		UPDATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS REPOSITORY_CURSOR (
+		REPO_ID UUID PRIMARY KEY,
+		LAST_EVENT_ID BIGINT NOT NULL DEFAULT 0,
+		LAST_EVENT_AT TIMESTAMPTZ NOT NULL,
+		UPDATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS PROCESSED_EVENT (
+		SOURCE TEXT NOT NULL,
+		EVENT_ID BIGINT NOT NULL,
+		REPO_ID UUID NOT NULL,
+		PROCESSED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (SOURCE, EVENT_ID)
+	)`,
+	`CREATE INDEX IF NOT EXISTS PROCESSED_EVENT_PROCESSED_AT_IDX ON PROCESSED_EVENT (PROCESSED_AT)`,
+	`CREATE TABLE IF NOT EXISTS SCHEDULED_JOB (
+		NAME TEXT PRIMARY KEY,
+		LAST_RUN_AT TIMESTAMPTZ NOT NULL,
+		UPDATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS JOB_QUEUE (
+		ID BIGSERIAL PRIMARY KEY,
+		KIND TEXT NOT NULL,
+		TARGET_ID UUID NOT NULL,
+		PAYLOAD JSONB NOT NULL DEFAULT '{}',
+		STATUS TEXT NOT NULL DEFAULT 'queued',
+		ATTEMPTS INT NOT NULL DEFAULT 0,
+		MAX_ATTEMPTS INT NOT NULL DEFAULT 3,
+		LAST_ERROR TEXT NOT NULL DEFAULT '',
+		RUN_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UPDATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	// A target has at most one pending job of each kind
+	`CREATE UNIQUE INDEX IF NOT EXISTS JOB_QUEUE_PENDING_IDX ON JOB_QUEUE (KIND, TARGET_ID) WHERE STATUS IN ('queued', 'running')`,
+	`CREATE INDEX IF NOT EXISTS JOB_QUEUE_RUN_AT_IDX ON JOB_QUEUE (RUN_AT) WHERE STATUS IN ('queued', 'running')`,
+	`CREATE TABLE IF NOT EXISTS RUN_HISTORY (
+		ID BIGSERIAL PRIMARY KEY,
+		KIND TEXT NOT NULL,
+		STARTED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FINISHED_AT TIMESTAMPTZ,
+		REPOSITORIES_FETCHED INT NOT NULL DEFAULT 0,
+		EVENTS_SCANNED INT NOT NULL DEFAULT 0,
+		ISSUES_MATCHED INT NOT NULL DEFAULT 0,
+		NOTIFICATIONS_CREATED INT NOT NULL DEFAULT 0,
+		EMAILS_SENT INT NOT NULL DEFAULT 0,
+		EMAILS_FAILED INT NOT NULL DEFAULT 0,
+		ERRORS TEXT[] NOT NULL DEFAULT '{}'
+	)`,
+	`CREATE INDEX IF NOT EXISTS RUN_HISTORY_STARTED_AT_IDX ON RUN_HISTORY (STARTED_AT)`,
+	`CREATE TABLE IF NOT EXISTS RUN_REPOSITORY (
+		RUN_ID BIGINT NOT NULL REFERENCES RUN_HISTORY (ID) ON DELETE CASCADE,
+		REPO_ID UUID NOT NULL,
+		REPO_NAME TEXT NOT NULL,
+		STATUS TEXT NOT NULL DEFAULT 'pending',
+		EVENTS_SCANNED INT NOT NULL DEFAULT 0,
+		ISSUES_MATCHED INT NOT NULL DEFAULT 0,
+		NOTIFICATIONS_CREATED INT NOT NULL DEFAULT 0,
+		ERROR TEXT NOT NULL DEFAULT '',
+		UPDATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (RUN_ID, REPO_ID)
+	)`,
+	`CREATE INDEX IF NOT EXISTS RUN_REPOSITORY_REPO_ID_IDX ON RUN_REPOSITORY (REPO_ID)`,
+	`CREATE TABLE IF NOT EXISTS RUN_USER (
+		RUN_ID BIGINT NOT NULL REFERENCES RUN_HISTORY (ID) ON DELETE CASCADE,
+		USER_ID UUID NOT NULL,
+		USERNAME TEXT NOT NULL,
+		STATUS TEXT NOT NULL DEFAULT 'pending',
+		ISSUES INT NOT NULL DEFAULT 0,
+		ERROR TEXT NOT NULL DEFAULT '',
+		UPDATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (RUN_ID, USER_ID)
+	)`,
+	`CREATE INDEX IF NOT EXISTS RUN_USER_USER_ID_IDX ON RUN_USER (USER_ID)`,
+	`CREATE TABLE IF NOT EXISTS REPOSITORY_WEBHOOK (
+		REPO_ID UUID PRIMARY KEY,
+		COVERED_SINCE TIMESTAMPTZ NOT NULL,
+		LAST_DELIVERY_AT TIMESTAMPTZ NOT NULL
+	)`,
+}
+
+// Migrate creates the tables owned by this service if they do not exist
+func Migrate() {
+	for _, m := range migrations {
+		if _, err := DB.Exec(m); err != nil {
+			utils.LogError.Fatalln("Failed to run database migration:", m, ". Error:", err)
+		}
+	}
+	utils.LogInfo.Println("Successfully ran", len(migrations), "database migrations")
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons for which an event gets skipped
+var (
+	ErrMalformed        = errors.New("malformed event")
+	ErrMissingCreatedAt = errors.New("missing created_at")
+	ErrMissingIssue     = errors.New("missing issue")
+	ErrMissingLabel     = errors.New("missing label")
+	ErrPullRequest      = errors.New("pull request event")
+)
+
+// ValidationError is returned for an event which cannot be decoded or matched
+type ValidationError struct {
+	EventID int64
+	Index   int
+	// CreatedAt is the creation time of the event if it could be decoded
+	CreatedAt time.Time
+	Err       error
+	Detail    string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("event %d (index %d): %v: %v", e.EventID, e.Index, e.Err, e.Detail)
+	}
+
+	return fmt.Sprintf("event %d (index %d): %v", e.EventID, e.Index, e.Err)
+}
+
+// Unwrap returns the reason the event got skipped
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Decode decodes a page of the issue events API. Each event is decoded and validated on its own so
+// an unexpected payload only skips that event; the skipped events are returned as *ValidationError.
+// An error is returned only if the page itself is not a JSON array.
+func Decode(data []byte) ([]Event, []error, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("[Decode]: %v", err)
+	}
+
+	events := make([]Event, 0, len(raw))
+	var skipped []error
+	for i, r := range raw {
+		var e Event
+		if err := json.Unmarshal(r, &e); err != nil {
+			skipped = append(skipped, &ValidationError{EventID: e.ID, Index: i, Err: ErrMalformed, Detail: err.Error()})
+			continue
+		}
+
+		if err := e.Validate(); err != nil {
+			vErr := err.(*ValidationError)
+			vErr.Index = i
+			vErr.CreatedAt = e.CreatedAt
+			skipped = append(skipped, err)
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	return events, skipped, nil
+}
+
+// Stats counts the events which were decoded and the ones which got skipped by reason
+type Stats struct {
+	Decoded int
+	Skipped map[string]int
+}
+
+// Add records the outcome of decoding a page of events
+func (s *Stats) Add(decoded int, skipped []error) {
+	s.Decoded += decoded
+	for _, err := range skipped {
+		if s.Skipped == nil {
+			s.Skipped = make(map[string]int)
+		}
+
+		var vErr *ValidationError
+		if errors.As(err, &vErr) {
+			s.Skipped[vErr.Err.Error()]++
+		} else {
+			s.Skipped[err.Error()]++
+		}
+	}
+}
+
+// SkippedCount returns the total number of skipped events
+func (s Stats) SkippedCount() int {
+	count := 0
+	for _, c := range s.Skipped {
+		count += c
+	}
+
+	return count
+}
+
+func (s Stats) String() string {
+	reasons := make([]string, 0, len(s.Skipped))
+	for reason, count := range s.Skipped {
+		reasons = append(reasons, fmt.Sprintf("%v: %d", reason, count))
+	}
+	sort.Strings(reasons)
+
+	return fmt.Sprintf("decoded %d, skipped %d [%v]", s.Decoded, s.SkippedCount(), strings.Join(reasons, ", "))
+}
+
+// DecodeIssues decodes a page of the list repository issues API. Issues which cannot be decoded
+// are left out of the returned page.
+func DecodeIssues(data []byte) ([]*Issue, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("[DecodeIssues]: %v", err)
+	}
+
+	issues := make([]*Issue, 0, len(raw))
+	for _, r := range raw {
+		var i Issue
+		if err := json.Unmarshal(r, &i); err != nil || i.Number == 0 {
+			continue
+		}
+
+		i.normalize()
+		issues = append(issues, &i)
+	}
+
+	return issues, nil
+}
+
+// DecodeIssue decodes a single issue
+func DecodeIssue(data []byte) (*Issue, error) {
+	var i Issue
+	if err := json.Unmarshal(data, &i); err != nil {
+		return nil, fmt.Errorf("[DecodeIssue]: %v", err)
+	}
+
+	i.normalize()
+	return &i, nil
+}
@@ -0,0 +1,114 @@
+package events
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	const issue = `{"number": 1, "title": "Bug", "state": "open", "labels": [{"name": "bug"}, null], "assignees": [null]}`
+
+	tests := []struct {
+		name        string
+		event       string
+		wantSkipped error
+	}{
+		{
+			name:  "labeled event",
+			event: `{"id": 1, "event": "labeled", "created_at": "2021-01-01T10:00:00Z", "issue": ` + issue + `, "label": {"name": "bug"}}`,
+		},
+		{
+			name:  "closed event without label",
+			event: `{"id": 2, "event": "closed", "created_at": "2021-01-01T10:00:00Z", "issue": ` + issue + `}`,
+		},
+		{
+			name:        "malformed event",
+			event:       `{"id": "3", "event": "labeled"}`,
+			wantSkipped: ErrMalformed,
+		},
+		{
+			name:        "missing created_at",
+			event:       `{"id": 4, "event": "labeled", "issue": ` + issue + `, "label": {"name": "bug"}}`,
+			wantSkipped: ErrMissingCreatedAt,
+		},
+		{
+			name:        "missing issue",
+			event:       `{"id": 5, "event": "labeled", "created_at": "2021-01-01T10:00:00Z", "label": {"name": "bug"}}`,
+			wantSkipped: ErrMissingIssue,
+		},
+		{
+			name:        "pull request",
+			event:       `{"id": 6, "event": "labeled", "created_at": "2021-01-01T10:00:00Z", "issue": {"number": 2, "pull_request": {}}, "label": {"name": "bug"}}`,
+			wantSkipped: ErrPullRequest,
+		},
+		{
+			name:        "labeled event without label",
+			event:       `{"id": 7, "event": "labeled", "created_at": "2021-01-01T10:00:00Z", "issue": ` + issue + `}`,
+			wantSkipped: ErrMissingLabel,
+		},
+		{
+			name:        "unlabeled event with an empty label",
+			event:       `{"id": 8, "event": "unlabeled", "created_at": "2021-01-01T10:00:00Z", "issue": ` + issue + `, "label": {"name": ""}}`,
+			wantSkipped: ErrMissingLabel,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each event is decoded on its own, after a valid one, so that the index is checked too
+			valid := `{"id": 10, "event": "closed", "created_at": "2021-01-01T09:00:00Z", "issue": ` + issue + `}`
+			decoded, skipped, err := Decode([]byte(`[` + valid + `, ` + tt.event + `]`))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if tt.wantSkipped == nil {
+				if len(skipped) != 0 || len(decoded) != 2 {
+					t.Fatalf("Decode() = %d decoded, skipped %v, want 2 decoded", len(decoded), skipped)
+				}
+
+				// The null labels and assignees are dropped
+				if got := decoded[1].Issue; len(got.Labels) != 1 || len(got.Assignees) != 0 {
+					t.Errorf("issue labels = %v, assignees = %v, want the null entries dropped", got.Labels, got.Assignees)
+				}
+				return
+			}
+
+			if len(decoded) != 1 || len(skipped) != 1 {
+				t.Fatalf("Decode() = %d decoded, skipped %v, want 1 decoded and 1 skipped", len(decoded), skipped)
+			}
+			if !errors.Is(skipped[0], tt.wantSkipped) {
+				t.Errorf("skipped reason = %v, want %v", skipped[0], tt.wantSkipped)
+			}
+
+			var vErr *ValidationError
+			if !errors.As(skipped[0], &vErr) || vErr.Index != 1 {
+				t.Errorf("skipped = %#v, want a *ValidationError at index 1", skipped[0])
+			}
+		})
+	}
+}
+
+func TestDecodeNotAnArray(t *testing.T) {
+	_, _, err := Decode([]byte(`{"message": "Not Found"}`))
+	if err == nil {
+		t.Error("Decode() error = nil, want an error for a page which is not an array")
+	}
+}
+
+func TestStats(t *testing.T) {
+	var s Stats
+	s.Add(3, []error{
+		&ValidationError{Err: ErrMissingLabel},
+		&ValidationError{Err: ErrMissingLabel},
+		&ValidationError{Err: ErrPullRequest},
+	})
+	s.Add(2, nil)
+
+	if s.Decoded != 5 || s.SkippedCount() != 3 {
+		t.Errorf("Stats = %+v, want 5 decoded and 3 skipped", s)
+	}
+	if want := "decoded 5, skipped 3 [missing label: 2, pull request event: 1]"; s.String() != want {
+		t.Errorf("String() = %q, want %q", s.String(), want)
+	}
+}
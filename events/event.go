@@ -0,0 +1,129 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Label struct defines a label as returned by the GitHub API
+type Label struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// User struct defines a user as returned by the GitHub API
+type User struct {
+	Login string `json:"login"`
+}
+
+// Issue struct defines an issue as returned by the GitHub API
+type Issue struct {
+	Number      int              `json:"number"`
+	Title       string           `json:"title"`
+	State       string           `json:"state"`
+	Labels      []*Label         `json:"labels"`
+	Assignees   []*User          `json:"assignees"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	PullRequest *json.RawMessage `json:"pull_request"`
+}
+
+// IsPullRequest reports whether the issue is actually a pull request
+func (i Issue) IsPullRequest() bool {
+	return i.PullRequest != nil
+}
+
+// normalize drops the `null` entries GitHub sometimes sends in the labels and assignees
+func (i *Issue) normalize() {
+	labels := i.Labels[:0]
+	for _, l := range i.Labels {
+		if l != nil && l.Name != "" {
+			labels = append(labels, l)
+		}
+	}
+	i.Labels = labels
+
+	assignees := i.Assignees[:0]
+	for _, a := range i.Assignees {
+		if a != nil {
+			assignees = append(assignees, a)
+		}
+	}
+	i.Assignees = assignees
+}
+
+// Event struct defines an issue event as returned by the GitHub issue events API
+type Event struct {
+	ID        int64     `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Issue     *Issue    `json:"issue"`
+	Label     *Label    `json:"label"`
+	Assignee  *User     `json:"assignee"`
+}
+
+// Key identifies the event within its repository by its issue, type, time and label or assignee
+// rather than by the ID its host gave it, so that an event received both from a webhook delivery and
+// from the issue events API is recognized as the same one. The event must be validated.
+func (e Event) Key() string {
+	subject := ""
+	switch {
+	case e.Label != nil:
+		subject = e.Label.Name
+	case e.Assignee != nil:
+		subject = e.Assignee.Login
+	}
+
+	return fmt.Sprintf("%d:%v:%v:%v", e.Issue.Number, e.Event, e.CreatedAt.UTC().Format(time.RFC3339), subject)
+}
+
+// Validate checks that the event carries everything needed to be matched against the subscriptions
+// and normalizes its issue
+func (e *Event) Validate() error {
+	if e.CreatedAt.IsZero() {
+		return &ValidationError{EventID: e.ID, Err: ErrMissingCreatedAt}
+	}
+	if e.Issue == nil {
+		return &ValidationError{EventID: e.ID, Err: ErrMissingIssue}
+	}
+	if e.Issue.IsPullRequest() {
+		return &ValidationError{EventID: e.ID, Err: ErrPullRequest}
+	}
+	if (e.Event == "labeled" || e.Event == "unlabeled") && (e.Label == nil || e.Label.Name == "") {
+		return &ValidationError{EventID: e.ID, Err: ErrMissingLabel}
+	}
+
+	e.Issue.normalize()
+
+	return nil
+}
+
+// IssuesPayload struct defines the payload of an `issues` webhook delivery
+type IssuesPayload struct {
+	Action     string `json:"action"`
+	Issue      *Issue `json:"issue"`
+	Label      *Label `json:"label"`
+	Assignee   *User  `json:"assignee"`
+	Repository *struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// Event converts the webhook payload to the same shape as an event of the issue events API. The
+// payload does not carry the time of the event, the issue got updated by it so its update time is
+// used, or the delivery time if it is missing.
+func (p IssuesPayload) Event(deliveredAt time.Time) Event {
+	createdAt := deliveredAt
+	if p.Issue != nil && !p.Issue.UpdatedAt.IsZero() {
+		createdAt = p.Issue.UpdatedAt
+	}
+
+	return Event{
+		Event:     p.Action,
+		CreatedAt: createdAt,
+		Issue:     p.Issue,
+		Label:     p.Label,
+		Assignee:  p.Assignee,
+	}
+}
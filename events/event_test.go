@@ -0,0 +1,54 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWebhookEventKeyMatchesPolledEvent(t *testing.T) {
+	const polled = `{"id": 1001, "event": "labeled", "created_at": "2021-01-01T10:00:00Z",
+		"issue": {"number": 7, "state": "open", "updated_at": "2021-01-01T10:00:00Z"}, "label": {"name": "bug"}}`
+	const delivered = `{"action": "labeled", "issue": {"number": 7, "state": "open", "updated_at": "2021-01-01T10:00:00Z"},
+		"label": {"name": "bug"}, "repository": {"full_name": "o/r"}}`
+
+	var e Event
+	if err := json.Unmarshal([]byte(polled), &e); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	var p IssuesPayload
+	if err := json.Unmarshal([]byte(delivered), &p); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	webhookEvent := p.Event(time.Date(2021, 1, 1, 10, 0, 3, 0, time.UTC))
+
+	if e.Key() != webhookEvent.Key() {
+		t.Errorf("Key() = %q for the polled event and %q for the delivered one, want the same key", e.Key(), webhookEvent.Key())
+	}
+}
+
+func TestEventKey(t *testing.T) {
+	createdAt := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	base := Event{ID: 1, Event: "labeled", CreatedAt: createdAt, Issue: &Issue{Number: 7}, Label: &Label{Name: "bug"}}
+
+	tests := []struct {
+		name     string
+		event    Event
+		wantSame bool
+	}{
+		{"another ID", Event{ID: 2, Event: "labeled", CreatedAt: createdAt, Issue: &Issue{Number: 7}, Label: &Label{Name: "bug"}}, true},
+		{"same instant in another location", Event{Event: "labeled", CreatedAt: createdAt.In(time.FixedZone("IST", 5*3600+1800)), Issue: &Issue{Number: 7}, Label: &Label{Name: "bug"}}, true},
+		{"another label of the same second", Event{Event: "labeled", CreatedAt: createdAt, Issue: &Issue{Number: 7}, Label: &Label{Name: "feature"}}, false},
+		{"another issue", Event{Event: "labeled", CreatedAt: createdAt, Issue: &Issue{Number: 8}, Label: &Label{Name: "bug"}}, false},
+		{"another type", Event{Event: "unlabeled", CreatedAt: createdAt, Issue: &Issue{Number: 7}, Label: &Label{Name: "bug"}}, false},
+		{"another second", Event{Event: "labeled", CreatedAt: createdAt.Add(time.Second), Issue: &Issue{Number: 7}, Label: &Label{Name: "bug"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := tt.event.Key() == base.Key(); same != tt.wantSame {
+				t.Errorf("Key() = %q, base key %q, want same %v", tt.event.Key(), base.Key(), tt.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// installationTokenRefreshMargin is how long before its expiry an installation token gets refreshed
+const installationTokenRefreshMargin = 5 * time.Minute
+
+// notInstalledTTL is how long an owner who has not installed the app is not looked up again
+const notInstalledTTL = 10 * time.Minute
+
+// ErrNotInstalled is returned when the GitHub App is not installed for a repository owner
+var ErrNotInstalled = errors.New("GitHub App is not installed for the repository owner")
+
+// App authenticates as a GitHub App. It signs JWTs with the app's private key, exchanges them for
+// installation access tokens and caches those until shortly before they expire.
+type App struct {
+	BaseURL string
+	ID      int64
+
+	privateKey *rsa.PrivateKey
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// installationIDs are keyed by the login of the account the app is installed on
+	installationIDs map[string]int64
+	// notInstalled holds until when the accounts which have not installed the app are not looked up again
+	notInstalled map[string]time.Time
+	// tokens are keyed by installation ID and keep their rate limit state across refreshes
+	tokens    map[int64]*token
+	expiresAt map[int64]time.Time
+	// calls are the installation lookups and token refreshes in progress, so that concurrent requests
+	// wait for the same one instead of each making their own
+	calls map[string]*appCall
+}
+
+// appCall is a request to GitHub made on behalf of every request waiting for it
+type appCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewApp returns an App for the given app ID and PEM encoded private key, as downloaded from the
+// app's settings page
+func NewApp(baseURL string, id int64, privateKeyPEM []byte) (*App, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("[NewApp]: failed to decode PEM private key")
+	}
+
+	var privateKey *rsa.PrivateKey
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err == nil {
+		privateKey = key
+	} else {
+		pkcs8Key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("[NewApp]: %v", err)
+		}
+
+		rsaKey, ok := pkcs8Key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("[NewApp]: private key is not an RSA key")
+		}
+		privateKey = rsaKey
+	}
+
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	return &App{
+		BaseURL:         baseURL,
+		ID:              id,
+		privateKey:      privateKey,
+		httpClient:      &http.Client{},
+		installationIDs: make(map[string]int64),
+		notInstalled:    make(map[string]time.Time),
+		tokens:          make(map[int64]*token),
+		expiresAt:       make(map[int64]time.Time),
+		calls:           make(map[string]*appCall),
+	}, nil
+}
+
+// JWT returns a JSON Web Token which authenticates as the app itself, valid for 9 minutes
+func (a *App) JWT() (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		// Issued in the past to allow for clock drift, as recommended by GitHub
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.ID, 10),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("[JWT]: %v", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// installationToken returns the access token of the installation for the given `owner` or `owner/repo`,
+// refreshing it if it expires soon
+func (a *App) installationToken(ctx context.Context, target string) (*token, error) {
+	installationID, err := a.installationID(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	t, exists := a.tokens[installationID]
+	fresh := exists && time.Until(a.expiresAt[installationID]) > installationTokenRefreshMargin
+	a.mu.Unlock()
+	if fresh {
+		return t, nil
+	}
+
+	err = a.single(ctx, "token:"+strconv.FormatInt(installationID, 10), func() error {
+		value, expiresAt, err := a.createInstallationToken(ctx, installationID)
+		if err != nil {
+			return err
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		t, exists := a.tokens[installationID]
+		if !exists {
+			t = &token{remaining: -1}
+			a.tokens[installationID] = t
+		}
+		t.setCredential(value)
+		a.expiresAt[installationID] = expiresAt
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.tokens[installationID], nil
+}
+
+// installationID returns the ID of the installation for the given `owner` or `owner/repo`. Owners who
+// have not installed the app are remembered for notInstalledTTL, so that their repositories do not
+// cost a lookup per request.
+func (a *App) installationID(ctx context.Context, target string) (int64, error) {
+	owner := strings.SplitN(target, "/", 2)[0]
+
+	a.mu.Lock()
+	installationID, exists := a.installationIDs[owner]
+	retryAt, notInstalled := a.notInstalled[owner]
+	a.mu.Unlock()
+	if exists {
+		return installationID, nil
+	}
+	if notInstalled && time.Now().Before(retryAt) {
+		return 0, ErrNotInstalled
+	}
+
+	err := a.single(ctx, "installation:"+owner, func() error {
+		id, err := a.findInstallation(ctx, target)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if err == ErrNotInstalled {
+			a.notInstalled[owner] = time.Now().Add(notInstalledTTL)
+		}
+		if err != nil {
+			return err
+		}
+		delete(a.notInstalled, owner)
+		a.installationIDs[owner] = id
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.installationIDs[owner], nil
+}
+
+// single runs fn unless a call with the same key is already in progress, in which case it waits for
+// that call and returns its error instead. fn runs without holding the mutex.
+func (a *App) single(ctx context.Context, key string, fn func() error) error {
+	a.mu.Lock()
+	if call, exists := a.calls[key]; exists {
+		a.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &appCall{done: make(chan struct{})}
+	a.calls[key] = call
+	a.mu.Unlock()
+
+	call.err = fn()
+
+	a.mu.Lock()
+	delete(a.calls, key)
+	a.mu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// findInstallation returns the ID of the installation of the app for the given `owner/repo`, or for the
+// given user or organization
+func (a *App) findInstallation(ctx context.Context, target string) (int64, error) {
+	endpoints := []string{"/repos/" + target + "/installation"}
+	if !strings.Contains(target, "/") {
+		endpoints = []string{"/orgs/" + target + "/installation", "/users/" + target + "/installation"}
+	}
+
+	var dataBytes []byte
+	var status int
+	var err error
+	for _, endpoint := range endpoints {
+		dataBytes, status, err = a.request(ctx, "GET", endpoint)
+		if err != nil {
+			return 0, fmt.Errorf("[findInstallation]: %v", err)
+		}
+		if status != http.StatusNotFound {
+			break
+		}
+	}
+	if status == http.StatusNotFound {
+		return 0, ErrNotInstalled
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("Received %v from GitHub with message %v", status, string(dataBytes))
+	}
+
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(dataBytes, &installation); err != nil {
+		return 0, fmt.Errorf("[findInstallation]: %v", err)
+	}
+
+	return installation.ID, nil
+}
+
+// createInstallationToken exchanges a JWT for an access token of the given installation
+func (a *App) createInstallationToken(ctx context.Context, installationID int64) (string, time.Time, error) {
+	dataBytes, status, err := a.request(ctx, "POST", "/app/installations/"+strconv.FormatInt(installationID, 10)+"/access_tokens")
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("[createInstallationToken]: %v", err)
+	}
+	if status != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("Received %v from GitHub with message %v", status, string(dataBytes))
+	}
+
+	var accessToken struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(dataBytes, &accessToken); err != nil {
+		return "", time.Time{}, fmt.Errorf("[createInstallationToken]: %v", err)
+	}
+
+	return accessToken.Token, accessToken.ExpiresAt, nil
+}
+
+// request makes a request authenticated as the app itself
+func (a *App) request(ctx context.Context, method, path string) ([]byte, int, error) {
+	jwt, err := a.JWT()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, method, a.BaseURL+path, nil)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	dataBytes, _ := ioutil.ReadAll(res.Body)
+	return dataBytes, res.StatusCode, nil
+}
@@ -0,0 +1,349 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// DefaultBaseURL is the base URL for the public GitHub REST API
+const DefaultBaseURL = "https://api.github.com"
+
+// APIBaseURL returns the base URL of the REST API for the GitHub host of the given web base URL. GitHub
+// Enterprise Server serves its API under `/api/v3` of its web host.
+func APIBaseURL(webBaseURL string) string {
+	webBaseURL = strings.TrimSuffix(webBaseURL, "/")
+	if webBaseURL == "" || webBaseURL == "https://github.com" {
+		return DefaultBaseURL
+	}
+
+	return webBaseURL + "/api/v3"
+}
+
+// Client is a GitHub REST API client which authenticates either as a GitHub App or using a pool of
+// tokens. Requests are spread across the tokens in a round-robin fashion and each token's rate limit
+// is tracked from the `X-RateLimit-*` and `Retry-After` response headers, so an exhausted token is
+// skipped until its limit resets.
+type Client struct {
+	BaseURL string
+
+	// MaxWait is the longest the client pauses for a token to become available again.
+	// If every token is limited for longer than this a *RateLimitError is returned.
+	MaxWait time.Duration
+
+	httpClient *http.Client
+
+	// app is set when authenticating as a GitHub App, in which case each installation has its own token
+	app *App
+
+	mu     sync.Mutex
+	tokens []*token
+	next   int
+}
+
+// token holds a single credential and the last known state of its rate limit
+type token struct {
+	// valueMu guards value, as installation tokens are refreshed while requests are made with them
+	valueMu sync.Mutex
+	value   string
+
+	remaining int // -1 when unknown
+	resetAt   time.Time
+}
+
+// credential returns the current value of the token
+func (t *token) credential() string {
+	t.valueMu.Lock()
+	defer t.valueMu.Unlock()
+
+	return t.value
+}
+
+// setCredential replaces the value of the token, keeping its rate limit state
+func (t *token) setCredential(value string) {
+	t.valueMu.Lock()
+	defer t.valueMu.Unlock()
+
+	t.value = value
+}
+
+// RateLimitError is returned when all tokens are rate limited for longer than MaxWait
+type RateLimitError struct {
+	RetryAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("GitHub rate limit exhausted for all tokens until %v", e.RetryAt.Format(time.RFC3339))
+}
+
+// NewClient returns a Client for the given baseURL. If no tokens are given the client
+// makes anonymous requests which are subject to GitHub's unauthenticated rate limit.
+func NewClient(baseURL string, tokens []string, maxWait time.Duration) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	c := &Client{
+		BaseURL:    baseURL,
+		MaxWait:    maxWait,
+		httpClient: &http.Client{},
+	}
+
+	for _, t := range tokens {
+		if t != "" {
+			c.tokens = append(c.tokens, &token{value: t, remaining: -1})
+		}
+	}
+	if len(c.tokens) == 0 {
+		c.tokens = []*token{{remaining: -1}}
+	}
+
+	return c
+}
+
+// GraphQLURL returns the URL of the GraphQL API. GitHub Enterprise Server serves it at `/api/graphql`
+// next to the REST API at `/api/v3`.
+func (c *Client) GraphQLURL() string {
+	if c.BaseURL == DefaultBaseURL {
+		return DefaultBaseURL + "/graphql"
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(c.BaseURL, "/"), "/v3") + "/graphql"
+}
+
+// IsApp reports whether the client authenticates as a GitHub App, in which case requests which
+// are not scoped to a repository only have access to the installation of a single owner
+func (c *Client) IsApp() bool {
+	return c.app != nil
+}
+
+// NewAppClient returns a Client which authenticates as the given GitHub App using the token of the
+// installation of each repository owner. The given tokens are used for owners which have not
+// installed the app.
+func NewAppClient(app *App, tokens []string, maxWait time.Duration) *Client {
+	c := NewClient(app.BaseURL, tokens, maxWait)
+	c.app = app
+
+	return c
+}
+
+// Do sends the request using the next available token. If the response shows that the
+// token got rate limited the request is retried with another token, pausing if all of
+// them are limited for at most MaxWait. Requests with a body must be created with a body
+// which can be read again, as set up by http.NewRequest, as they may be sent more than once.
+//
+// When authenticating as a GitHub App the installation is chosen from the repository in the
+// `/repos/{owner}/{repo}` path of the request.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req, repositoryFromPath(req.URL.Path))
+}
+
+// DoForOwner is the same as Do but uses the installation of the given user or organization when
+// authenticating as a GitHub App, for requests which are not scoped to a repository
+func (c *Client) DoForOwner(owner string, req *http.Request) (*http.Response, error) {
+	return c.do(req, owner)
+}
+
+// do sends the request with the tokens of the given `owner` or `owner/repo`
+func (c *Client) do(req *http.Request, target string) (*http.Response, error) {
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+	}
+
+	pool, err := c.pool(req, target)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt <= len(pool); attempt++ {
+		t, err := c.acquire(req, pool)
+		if err != nil {
+			return nil, err
+		}
+
+		if value := t.credential(); value != "" {
+			req.Header.Set("Authorization", "token "+value)
+		}
+		if attempt > 0 && req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.update(t, res) {
+			res.Body.Close()
+			continue
+		}
+
+		return res, nil
+	}
+
+	return nil, &RateLimitError{RetryAt: c.earliestReset(pool)}
+}
+
+// pool returns the tokens to use for requests of the given `owner` or `owner/repo`
+func (c *Client) pool(req *http.Request, target string) ([]*token, error) {
+	if c.app == nil || target == "" {
+		return c.tokens, nil
+	}
+
+	t, err := c.app.installationToken(req.Context(), target)
+	if err == ErrNotInstalled && c.tokens[0].credential() != "" {
+		return c.tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []*token{t}, nil
+}
+
+// acquire returns the next token of the pool which is not rate limited, pausing for at
+// most MaxWait if all tokens are currently limited
+func (c *Client) acquire(req *http.Request, pool []*token) (*token, error) {
+	c.mu.Lock()
+	now := time.Now()
+	for i := 0; i < len(pool); i++ {
+		t := pool[(c.next+i)%len(pool)]
+		if t.remaining != 0 || !now.Before(t.resetAt) {
+			c.next = (c.next + i + 1) % len(pool)
+			c.mu.Unlock()
+			return t, nil
+		}
+	}
+	c.mu.Unlock()
+
+	retryAt := c.earliestReset(pool)
+	wait := time.Until(retryAt)
+	if wait > c.MaxWait {
+		return nil, &RateLimitError{RetryAt: retryAt}
+	}
+
+	utils.LogInfo.Println("All GitHub tokens are rate limited. Pausing for:", wait.Round(time.Second))
+	select {
+	case <-time.After(wait):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range pool {
+		if !time.Now().Before(t.resetAt) {
+			t.remaining = -1
+			return t, nil
+		}
+	}
+
+	return nil, &RateLimitError{RetryAt: earliestReset(pool)}
+}
+
+// update records the rate limit state of the token from the response headers and reports
+// whether the request was rejected because of a rate limit and should be retried
+func (c *Client) update(t *token, res *http.Response) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining")); err == nil {
+		t.remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		t.resetAt = time.Unix(reset, 0)
+	}
+
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+
+	// Secondary rate limits are signalled with a `Retry-After` header in seconds
+	if retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		t.remaining = 0
+		t.resetAt = time.Now().Add(time.Duration(retryAfter) * time.Second)
+		return true
+	}
+
+	return t.remaining == 0
+}
+
+// Budget returns the number of requests the tokens of the client have left together and when the
+// last of them resets. The budget is unknown until a response of every token has been seen, and when
+// authenticating as a GitHub App as each installation has its own rate limit.
+func (c *Client) Budget() (remaining int, resetAt time.Time, known bool) {
+	if c.app != nil {
+		return 0, time.Time{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range c.tokens {
+		if t.remaining < 0 || !now.Before(t.resetAt) {
+			return 0, time.Time{}, false
+		}
+
+		remaining += t.remaining
+		if t.resetAt.After(resetAt) {
+			resetAt = t.resetAt
+		}
+	}
+
+	return remaining, resetAt, true
+}
+
+func (c *Client) earliestReset(pool []*token) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return earliestReset(pool)
+}
+
+func earliestReset(pool []*token) time.Time {
+	var earliest time.Time
+	for _, t := range pool {
+		if earliest.IsZero() || t.resetAt.Before(earliest) {
+			earliest = t.resetAt
+		}
+	}
+
+	return earliest
+}
+
+// repositoryFromPath returns the `owner/repo` of a `/repos/{owner}/{repo}` request path
+func repositoryFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] == "repos" {
+			return parts[i+1] + "/" + parts[i+2]
+		}
+	}
+
+	return ""
+}
+
+// NextPageURL returns the URL of the next page from the `Link` header of a paginated response, or an
+// empty string on the last page
+func NextPageURL(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+
+	return ""
+}
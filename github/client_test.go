@@ -0,0 +1,283 @@
+package github
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLogging("production")
+	os.Exit(m.Run())
+}
+
+func TestClientUpdate(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name          string
+		status        int
+		header        map[string]string
+		wantRetry     bool
+		wantRemaining int
+		// wantResetIn is the time until the reset, compared to the second, or the reset header if 0
+		wantResetIn time.Duration
+	}{
+		{
+			name:          "rate limit headers are recorded",
+			status:        http.StatusOK,
+			header:        map[string]string{"X-RateLimit-Remaining": "42", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			wantRemaining: 42,
+		},
+		{
+			name:          "without headers the state is left unknown",
+			status:        http.StatusOK,
+			wantRemaining: -1,
+		},
+		{
+			name:          "exhausted primary rate limit is retried",
+			status:        http.StatusForbidden,
+			header:        map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			wantRetry:     true,
+			wantRemaining: 0,
+		},
+		{
+			name:          "forbidden with requests left is not a rate limit",
+			status:        http.StatusForbidden,
+			header:        map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			wantRemaining: 10,
+		},
+		{
+			name:          "secondary rate limit waits for Retry-After",
+			status:        http.StatusForbidden,
+			header:        map[string]string{"X-RateLimit-Remaining": "10", "Retry-After": "60"},
+			wantRetry:     true,
+			wantRemaining: 0,
+			wantResetIn:   time.Minute,
+		},
+		{
+			name:          "too many requests waits for Retry-After",
+			status:        http.StatusTooManyRequests,
+			header:        map[string]string{"Retry-After": "30"},
+			wantRetry:     true,
+			wantRemaining: 0,
+			wantResetIn:   30 * time.Second,
+		},
+		{
+			name:          "Retry-After of a successful response is ignored",
+			status:        http.StatusOK,
+			header:        map[string]string{"Retry-After": "30"},
+			wantRemaining: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("", []string{"a"}, 0)
+			tok := c.tokens[0]
+
+			res := &http.Response{StatusCode: tt.status, Header: make(http.Header)}
+			for k, v := range tt.header {
+				res.Header.Set(k, v)
+			}
+
+			if got := c.update(tok, res); got != tt.wantRetry {
+				t.Errorf("update() = %v, want %v", got, tt.wantRetry)
+			}
+			if tok.remaining != tt.wantRemaining {
+				t.Errorf("remaining = %v, want %v", tok.remaining, tt.wantRemaining)
+			}
+
+			switch {
+			case tt.wantResetIn != 0:
+				if d := time.Until(tok.resetAt) - tt.wantResetIn; d > time.Second || d < -time.Second {
+					t.Errorf("resetAt = %v, want in %v", tok.resetAt, tt.wantResetIn)
+				}
+			case tt.header["X-RateLimit-Reset"] != "":
+				if !tok.resetAt.Equal(reset) {
+					t.Errorf("resetAt = %v, want %v", tok.resetAt, reset)
+				}
+			}
+		})
+	}
+}
+
+func TestClientAcquire(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		// limited are the tokens which are rate limited, by value, until the given time
+		limited  map[string]time.Time
+		want     []string
+		wantWait bool
+		wantErr  bool
+	}{
+		{
+			name: "tokens are used in turn",
+			want: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:    "rate limited tokens are skipped",
+			limited: map[string]time.Time{"b": now.Add(time.Hour)},
+			want:    []string{"a", "c", "a", "c"},
+		},
+		{
+			name:    "tokens whose limit reset are used again",
+			limited: map[string]time.Time{"b": now.Add(-time.Second)},
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name:    "all tokens limited for longer than MaxWait",
+			maxWait: time.Minute,
+			limited: map[string]time.Time{"a": now.Add(time.Hour), "b": now.Add(2 * time.Hour), "c": now.Add(time.Hour)},
+			wantErr: true,
+		},
+		{
+			name:     "all tokens limited shortly pauses for the first to reset",
+			maxWait:  time.Minute,
+			limited:  map[string]time.Time{"a": now.Add(time.Hour), "b": now.Add(50 * time.Millisecond), "c": now.Add(time.Hour)},
+			want:     []string{"b"},
+			wantWait: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("", []string{"a", "b", "c"}, tt.maxWait)
+			for _, tok := range c.tokens {
+				if resetAt, exists := tt.limited[tok.value]; exists {
+					tok.remaining = 0
+					tok.resetAt = resetAt
+				}
+			}
+			req := httptest.NewRequest("GET", "/repos/o/r/issues/events", nil)
+
+			if tt.wantErr {
+				_, err := c.acquire(req, c.tokens)
+				var rateLimitErr *RateLimitError
+				if !errors.As(err, &rateLimitErr) {
+					t.Fatalf("acquire() error = %v, want *RateLimitError", err)
+				}
+				if !rateLimitErr.RetryAt.Equal(now.Add(time.Hour)) {
+					t.Errorf("RetryAt = %v, want the earliest reset %v", rateLimitErr.RetryAt, now.Add(time.Hour))
+				}
+				return
+			}
+
+			startedAt := time.Now()
+			for i, want := range tt.want {
+				tok, err := c.acquire(req, c.tokens)
+				if err != nil {
+					t.Fatalf("acquire() #%d error = %v", i, err)
+				}
+				if tok.value != want {
+					t.Errorf("acquire() #%d = %v, want %v", i, tok.value, want)
+				}
+			}
+
+			if waited := time.Since(startedAt) >= 50*time.Millisecond; waited != tt.wantWait {
+				t.Errorf("waited = %v, want %v", waited, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestClientDoRotatesRateLimitedTokens(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "token a" {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "99")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, []string{"a", "b"}, 0)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", server.URL+"/repos/o/r/issues/events", nil)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Do() status = %v, want %v", res.StatusCode, http.StatusOK)
+		}
+	}
+
+	// The exhausted token is only tried once, the second request goes straight to the other one
+	want := []string{"token a", "token b", "token b"}
+	if len(authorizations) != len(want) {
+		t.Fatalf("authorizations = %v, want %v", authorizations, want)
+	}
+	for i := range want {
+		if authorizations[i] != want[i] {
+			t.Errorf("authorizations = %v, want %v", authorizations, want)
+			break
+		}
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{
+			name: "next and last",
+			link: `<https://api.github.com/repositories/1/issues/events?page=2>; rel="next", <https://api.github.com/repositories/1/issues/events?page=5>; rel="last"`,
+			want: "https://api.github.com/repositories/1/issues/events?page=2",
+		},
+		{
+			name: "next after prev",
+			link: `<https://api.github.com/repositories/1/issues/events?page=1>; rel="prev", <https://api.github.com/repositories/1/issues/events?page=3>; rel="next"`,
+			want: "https://api.github.com/repositories/1/issues/events?page=3",
+		},
+		{
+			name: "last page",
+			link: `<https://api.github.com/repositories/1/issues/events?page=4>; rel="prev", <https://api.github.com/repositories/1/issues/events?page=1>; rel="first"`,
+			want: "",
+		},
+		{
+			name: "cursor pagination without spaces",
+			link: `<https://api.github.com/repositories/1/issues/events?after=abc&per_page=100>;rel="next"`,
+			want: "https://api.github.com/repositories/1/issues/events?after=abc&per_page=100",
+		},
+		{
+			name: "no link header",
+			want: "",
+		},
+		{
+			name: "malformed link",
+			link: `<https://api.github.com/repositories/1/issues/events?page=2>`,
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make(http.Header)
+			if tt.link != "" {
+				header.Set("Link", tt.link)
+			}
+
+			if got := NextPageURL(header); got != tt.want {
+				t.Errorf("NextPageURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
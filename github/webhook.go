@@ -0,0 +1,30 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ValidateSignature checks the `X-Hub-Signature-256` header value of a webhook delivery against
+// the HMAC-SHA256 of its payload computed with the webhook secret
+func ValidateSignature(signature string, payload, secret []byte) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errors.New("missing or malformed `X-Hub-Signature-256` header")
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return errors.New("malformed `X-Hub-Signature-256` header")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("payload signature does not match")
+	}
+
+	return nil
+}
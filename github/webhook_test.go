@@ -0,0 +1,44 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sign(payload, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateSignature(t *testing.T) {
+	payload := []byte(`{"action":"labeled"}`)
+	secret := []byte("It's a Secret to Everybody")
+
+	tests := []struct {
+		name      string
+		signature string
+		payload   []byte
+		wantErr   bool
+	}{
+		{"valid signature", sign(payload, secret), payload, false},
+		{"missing header", "", payload, true},
+		{"SHA-1 signature", "sha1=" + sign(payload, secret)[len("sha256="):], payload, true},
+		{"not hex encoded", "sha256=not-hex", payload, true},
+		{"signed with another secret", sign(payload, []byte("another secret")), payload, true},
+		{"tampered payload", sign(payload, secret), []byte(`{"action":"unlabeled"}`), true},
+		{"truncated signature", sign(payload, secret)[:20], payload, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSignature(tt.signature, tt.payload, secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/cron"
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/sources"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// fetchTasks returns a task per repository processing its new issue events and recording its outcome
+// for the given run
+func fetchTasks(ctx context.Context, runID int64, repositories []services.Repository) []stageTask {
+	prefetched := make(map[uuid.UUID]*sources.EventList)
+	if githubFetcher == "graphql" {
+		prefetched = prefetchWithGraphQL(ctx, repositories)
+	}
+
+	tasks := make([]stageTask, 0, len(repositories))
+	for _, repository := range repositories {
+		repository := repository
+		tasks = append(tasks, stageTask{
+			key: repository.Host() + "/" + repository.RepoName,
+			run: func(ctx context.Context) error {
+				outcome, err := processIssueEvents(ctx, repository, prefetched[repository.RepoID])
+				recordRepository(runID, repository, outcome, err)
+				return err
+			},
+		})
+	}
+
+	return tasks
+}
+
+// runScheduler runs the fetch, send and cleanup jobs, either every TICKER_TIME hours or on their
+// cron schedules, until the context is cancelled
+func runScheduler(ctx context.Context, jobs []cron.Job) {
+	// Repositories are polled continuously on their own interval instead of all at once every tick
+	if pollMinInterval > 0 {
+		scheduler := newPollScheduler(time.Duration(pollMinInterval)*time.Minute, time.Duration(pollMaxInterval)*time.Minute, int(fetchConcurrency))
+		go scheduler.run(ctx)
+	}
+
+	if len(jobs) > 0 {
+		cron.NewScheduler(cron.RealClock{}, jobStore{}, jobs...).Run(ctx)
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(tickerTime) * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			utils.LogInfo.Println("Starting to grab issue events per repository")
+			start(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// start runs the fetch, send and cleanup jobs one after the other, until the context is cancelled, and
+// records them as a single run
+func start(ctx context.Context) {
+	runID := beginRun(cycleRunKind)
+	defer finishRun(runID)
+
+	fetchJob(ctx, runID)
+	if ctx.Err() != nil {
+		return
+	}
+	sendJob(ctx, runID)
+	if ctx.Err() != nil {
+		return
+	}
+	cleanupJob()
+}
+
+// fetchJob processes the new issue events of every repository and records their outcome for the given run
+func fetchJob(ctx context.Context, runID int64) {
+	// Repositories are fetched by the scheduler instead when they are polled continuously
+	if pollMinInterval > 0 {
+		return
+	}
+
+	repositories, err := services.GetAllRepositories()
+	if err != nil {
+		utils.LogError.Println("Failed to get all repositories. Error:", err)
+		recordRunError(runID, err)
+		return
+	}
+	utils.LogInfo.Println("Got", len(repositories), "repositories")
+	recordPendingRepositories(runID, repositories)
+
+	// TIME_GAP is the deadline of each stage
+	stageDeadline := time.Duration(timeGap) * time.Minute
+
+	if queueWorkers > 0 {
+		targets := make([]queuedTarget, 0, len(repositories))
+		for _, repository := range repositories {
+			payload := queuedRepository{Repository: repository, RunID: runID}
+			targets = append(targets, queuedTarget{key: repository.Host() + "/" + repository.RepoName, id: repository.RepoID, payload: payload})
+		}
+		runQueuedStage(ctx, "fetch", fetchJobKind, stageDeadline, targets).log()
+		return
+	}
+
+	fetchSummary := runStage(ctx, "fetch", int(fetchConcurrency), stageDeadline, fetchTasks(ctx, runID, repositories))
+	fetchSummary.log()
+}
+
+// sendJob revalidates the pending notification data and sends the digests, recording their outcome
+// for the given run
+func sendJob(ctx context.Context, runID int64) {
+	stageDeadline := time.Duration(timeGap) * time.Minute
+
+	revalidateTasks, err := revalidationTasks()
+	if err != nil {
+		utils.LogError.Println("Failed to revalidate pending notification data. Error:", err)
+		recordRunError(runID, err)
+	}
+	revalidateSummary := runStage(ctx, "revalidate", int(revalidateConcurrency), stageDeadline, revalidateTasks)
+	revalidateSummary.log()
+	if ctx.Err() != nil {
+		return
+	}
+
+	repositories, err := services.GetAllRepositories()
+	if err != nil {
+		utils.LogError.Println("Failed to get all repositories. Error:", err)
+		recordRunError(runID, err)
+		return
+	}
+
+	users, err := models.GetAllUsersWithPendingNotificationData()
+	if err != nil {
+		utils.LogError.Println("Failed to get all users with pending notification data. Error:", err)
+		recordRunError(runID, err)
+		return
+	}
+	utils.LogInfo.Println("Got", len(users), "users with pending notification data")
+	recordPendingUsers(runID, users)
+
+	if queueWorkers > 0 {
+		targets := make([]queuedTarget, 0, len(users))
+		for _, user := range users {
+			targets = append(targets, queuedTarget{key: user.Username, id: user.UserID, payload: queuedUser{User: user, RunID: runID}})
+		}
+		runQueuedStage(ctx, "send", deliverJobKind, stageDeadline, targets).log()
+		return
+	}
+
+	repositoriesByID := make(map[string]services.Repository, len(repositories))
+	for _, repository := range repositories {
+		repositoriesByID[repository.RepoID.String()] = repository
+	}
+
+	sendTasks := make([]stageTask, 0, len(users))
+	for _, user := range users {
+		user := user
+		sendTasks = append(sendTasks, stageTask{
+			key: user.Username,
+			run: func(ctx context.Context) error {
+				issues, err := sendEmail(user, repositoriesByID)
+				recordUser(runID, user, issues, err)
+				return err
+			},
+		})
+	}
+	sendSummary := runStage(ctx, "send", int(sendConcurrency), stageDeadline, sendTasks)
+	sendSummary.log()
+}
+
+// cleanupJob deletes the sent notification data, the expired processed events, finished jobs and runs
+func cleanupJob() {
+	err := models.DeleteAllSentNotificationData()
+	if err != nil {
+		utils.LogError.Println("Failed to deleted all notification data with `sent` status equal to `true`. Error:", err)
+		return
+	}
+	utils.LogInfo.Println("Successfully deleted all notification data with `sent` status equal to `true`")
+
+	deleted, err := models.DeleteExpiredProcessedEvents(time.Duration(processedEventTTL) * time.Hour)
+	if err != nil {
+		utils.LogError.Println("Failed to delete expired processed events. Error:", err)
+		return
+	}
+	utils.LogInfo.Println("Successfully deleted", deleted, "processed events older than", processedEventTTL, "hours")
+
+	// Finished jobs are kept as long as the processed events to look into why they failed
+	deleted, err = models.DeleteFinishedJobs(time.Duration(processedEventTTL) * time.Hour)
+	if err != nil {
+		utils.LogError.Println("Failed to delete finished jobs. Error:", err)
+		return
+	}
+	utils.LogInfo.Println("Successfully deleted", deleted, "finished jobs older than", processedEventTTL, "hours")
+
+	deleted, err = models.DeleteExpiredRuns(time.Duration(runHistoryTTL) * time.Hour)
+	if err != nil {
+		utils.LogError.Println("Failed to delete expired runs. Error:", err)
+		return
+	}
+	utils.LogInfo.Println("Successfully deleted", deleted, "runs older than", runHistoryTTL, "hours")
+}
+
+// scheduledJobs returns the fetch, send and cleanup jobs on their cron schedules. The jobs without
+// a schedule run every TICKER_TIME hours.
+func scheduledJobs() ([]cron.Job, error) {
+	defaultSchedule := fmt.Sprintf("@every %vh", tickerTime)
+
+	jobs := []cron.Job{
+		{Name: "fetch", Run: recordedJob(fetchRunKind, fetchJob)},
+		{Name: "send", Run: recordedJob(sendRunKind, sendJob)},
+		{Name: "cleanup", Run: func(ctx context.Context) { cleanupJob() }},
+	}
+	for i, expr := range []string{fetchSchedule, sendSchedule, cleanupSchedule} {
+		if expr == "" {
+			expr = defaultSchedule
+		}
+
+		schedule, err := cron.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("[scheduledJobs]: schedule of job %v: %v", jobs[i].Name, err)
+		}
+		jobs[i].Schedule = schedule
+	}
+
+	return jobs, nil
+}
+
+// recordedJob returns a job recording each of its runs as a run of the given kind
+func recordedJob(kind string, job func(ctx context.Context, runID int64)) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		runID := beginRun(kind)
+		defer finishRun(runID)
+
+		job(ctx, runID)
+	}
+}
+
+// jobStore persists the last run of the scheduled jobs in the database
+type jobStore struct{}
+
+func (jobStore) LastRun(name string) (time.Time, bool, error) {
+	return models.GetScheduledJobLastRunAt(name)
+}
+
+func (jobStore) SetLastRun(name string, t time.Time) error {
+	return models.UpsertScheduledJobLastRunAt(name, t)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Match modes of a label subscription
+const (
+	matchExact           = "exact"
+	matchCaseInsensitive = "case-insensitive"
+	matchGlob            = "glob"
+	matchRegex           = "regex"
+)
+
+// labelPattern is the label of a subscription compiled according to its match mode
+type labelPattern struct {
+	// pattern is the label, glob or regular expression as subscribed to
+	pattern string
+	mode    string
+	re      *regexp.Regexp
+}
+
+// compileLabelPattern compiles the pattern of a subscription. Subscriptions without a match mode match
+// the label name exactly. Globs support `*` and `?` and, like case-insensitive patterns, ignore the
+// case as label names are unique regardless of it on GitHub. Regular expressions must match the whole
+// label name.
+func compileLabelPattern(pattern, mode string) (labelPattern, error) {
+	p := labelPattern{pattern: pattern, mode: mode}
+
+	var err error
+	switch mode {
+	case "", matchExact:
+		p.mode = matchExact
+	case matchCaseInsensitive:
+		p.re, err = regexp.Compile("(?i)^" + regexp.QuoteMeta(pattern) + "$")
+	case matchGlob:
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		p.re, err = regexp.Compile("(?i)^" + expr + "$")
+	case matchRegex:
+		p.re, err = regexp.Compile("^(?:" + pattern + ")$")
+	default:
+		err = fmt.Errorf("unknown match mode %q", mode)
+	}
+	if err != nil {
+		return labelPattern{}, fmt.Errorf("[compileLabelPattern]: %v", err)
+	}
+
+	return p, nil
+}
+
+// key identifies the pattern, as the same label may be subscribed to with different match modes
+func (p labelPattern) key() string {
+	return p.mode + ":" + p.pattern
+}
+
+func (p labelPattern) matches(name string) bool {
+	if p.re == nil {
+		return p.pattern == name
+	}
+
+	return p.re.MatchString(name)
+}
+
+// labelMatcher matches label names against the patterns subscribed to in a repository
+type labelMatcher struct {
+	patterns map[string]labelPattern
+	// Used to cache the keys of the patterns matching a label name, as the same labels keep coming up
+	matched map[string][]string
+}
+
+func newLabelMatcher() *labelMatcher {
+	return &labelMatcher{
+		patterns: make(map[string]labelPattern),
+		matched:  make(map[string][]string),
+	}
+}
+
+// add adds the pattern and returns its key
+func (m *labelMatcher) add(p labelPattern) string {
+	key := p.key()
+	if _, exists := m.patterns[key]; !exists {
+		m.patterns[key] = p
+		m.matched = make(map[string][]string)
+	}
+
+	return key
+}
+
+// match returns the keys of the patterns matching the label name
+func (m *labelMatcher) match(name string) []string {
+	if keys, exists := m.matched[name]; exists {
+		return keys
+	}
+
+	var keys []string
+	for key, p := range m.patterns {
+		if p.matches(name) {
+			keys = append(keys, key)
+		}
+	}
+	m.matched[name] = keys
+
+	return keys
+}
+
+// matchesAny reports whether the pattern of the given key matches any of the label names
+func (m *labelMatcher) matchesAny(key string, labelNames []string) bool {
+	for _, name := range labelNames {
+		if m.patterns[key].matches(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pattern returns the pattern of the given key as subscribed to
+func (m *labelMatcher) pattern(key string) string {
+	return m.patterns[key].pattern
+}
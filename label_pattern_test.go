@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestCompileLabelPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		mode    string
+		// matches and misses are label names the pattern must and must not match
+		matches []string
+		misses  []string
+		wantErr bool
+	}{
+		{pattern: "good first issue", mode: "", matches: []string{"good first issue"}, misses: []string{"Good First Issue", "good first issues"}},
+		{pattern: "good first issue", mode: matchExact, matches: []string{"good first issue"}, misses: []string{"Good First Issue"}},
+		{pattern: "Good First Issue", mode: matchCaseInsensitive, matches: []string{"good first issue", "GOOD FIRST ISSUE"}, misses: []string{"good first issues"}},
+		{pattern: "c++", mode: matchCaseInsensitive, matches: []string{"C++"}, misses: []string{"cc"}},
+		{pattern: "good*first*", mode: matchGlob, matches: []string{"good-first-issue", "Good First Timers", "goodfirst"}, misses: []string{"very good first issue"}},
+		{pattern: "area/?", mode: matchGlob, matches: []string{"area/a"}, misses: []string{"area/", "area/ab"}},
+		{pattern: "p[1]", mode: matchGlob, matches: []string{"p[1]"}, misses: []string{"p1"}},
+		{pattern: "(good|easy) first.*", mode: matchRegex, matches: []string{"good first issue", "easy first"}, misses: []string{"a good first issue", "Good first issue"}},
+		{pattern: "bug|feature", mode: matchRegex, matches: []string{"bug", "feature"}, misses: []string{"bugfix", "a feature"}},
+		{pattern: "(unclosed", mode: matchRegex, wantErr: true},
+		{pattern: "bug", mode: "fuzzy", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+":"+tt.pattern, func(t *testing.T) {
+			p, err := compileLabelPattern(tt.pattern, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileLabelPattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			for _, name := range tt.matches {
+				if !p.matches(name) {
+					t.Errorf("matches(%q) = false, want true", name)
+				}
+			}
+			for _, name := range tt.misses {
+				if p.matches(name) {
+					t.Errorf("matches(%q) = true, want false", name)
+				}
+			}
+		})
+	}
+}
+
+func TestLabelPatternKey(t *testing.T) {
+	exact, _ := compileLabelPattern("bug", "")
+	caseInsensitive, _ := compileLabelPattern("bug", matchCaseInsensitive)
+
+	if exact.key() == caseInsensitive.key() {
+		t.Errorf("key() = %q for both match modes, want different keys", exact.key())
+	}
+
+	explicit, _ := compileLabelPattern("bug", matchExact)
+	if exact.key() != explicit.key() {
+		t.Errorf("key() = %q and %q, want the default mode to be exact", exact.key(), explicit.key())
+	}
+}
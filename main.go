@@ -2,21 +2,26 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"net/smtp"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/cron"
 	"github.com/issue-notifier/notification-service/database"
+	"github.com/issue-notifier/notification-service/github"
 	"github.com/issue-notifier/notification-service/models"
 	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/sources"
 	"github.com/issue-notifier/notification-service/utils"
 	"github.com/joho/godotenv"
 )
@@ -35,19 +40,59 @@ var (
 
 	issueNotifierAPIEndpoint string
 
-	tickerTime int64 // in hours
-	timeGap    int64 // in minutes
+	githubTokens           []string
+	githubEnterpriseTokens map[string]string
+	githubMaxWait          int64 // in minutes
+	githubAppID            int64
+	githubAppPrivateKey    string
+	githubFetcher          string // `rest` or `graphql`
+	githubGraphQLBatchSize int64
+	maxPagesPerRun         int64
+
+	gitlabBaseURL string
+	gitlabToken   string
+
+	giteaTokens map[string]string
+
+	port                  string
+	githubWebhookSecret   string
+	webhookCoverageWindow int64 // in hours
+
+	tickerTime            int64 // in hours
+	timeGap               int64 // in minutes
+	processedEventTTL     int64 // in hours
+	runHistoryTTL         int64 // in hours
+	fetchConcurrency      int64
+	revalidateConcurrency int64
+	sendConcurrency       int64
+	pollMinInterval       int64 // in minutes
+	pollMaxInterval       int64 // in minutes
+	queueWorkers          int64
+	queueVisibility       int64 // in minutes
+	shutdownGracePeriod   int64 // in seconds
+
+	fetchSchedule   string
+	sendSchedule    string
+	cleanupSchedule string
 
 	Layout1  string = "2006-01-02T15:04:05-07:00"
 	Layout2  string = "2006-01-02T15:04:05Z"
 	Layout3  string = "Jan 02, 2006 15:04"
 	BaseTime time.Time
+
+	githubClients map[string]*github.Client
+	githubGraphQL *sources.GitHubGraphQL
 )
 
+// leaderCheckInterval is how often the leader checks it still holds the leader lock and the standby
+// replicas try to take it over
+const leaderCheckInterval = 30 * time.Second
+
 type repositoryData struct {
 	RepoName    string
 	LastEventAt string
 	Issues      []models.Issue
+	Repository  services.Repository
 }
 
 func main() {
@@ -72,273 +117,414 @@ func main() {
 	issueNotifierAPIEndpoint = os.Getenv("ISSUE_NOTIFIER_API_ENDPOINT")
 	tickerTime, _ = strconv.ParseInt(os.Getenv("TICKER_TIME"), 10, 32)
 	timeGap, _ = strconv.ParseInt(os.Getenv("TIME_GAP"), 10, 32)
+	processedEventTTL, _ = strconv.ParseInt(os.Getenv("PROCESSED_EVENT_TTL"), 10, 32)
+	if processedEventTTL <= 0 {
+		processedEventTTL = 7 * 24
+	}
+	runHistoryTTL, _ = strconv.ParseInt(os.Getenv("RUN_HISTORY_TTL"), 10, 32)
+	if runHistoryTTL <= 0 {
+		runHistoryTTL = 30 * 24
+	}
+	fetchConcurrency = parseConcurrency(os.Getenv("FETCH_CONCURRENCY"))
+	revalidateConcurrency = parseConcurrency(os.Getenv("REVALIDATE_CONCURRENCY"))
+	sendConcurrency = parseConcurrency(os.Getenv("SEND_CONCURRENCY"))
+	queueWorkers, _ = strconv.ParseInt(os.Getenv("QUEUE_WORKERS"), 10, 32)
+	queueVisibility, _ = strconv.ParseInt(os.Getenv("QUEUE_VISIBILITY_TIMEOUT"), 10, 32)
+	if queueVisibility <= 0 {
+		queueVisibility = 15
+	}
+	shutdownGracePeriod, _ = strconv.ParseInt(os.Getenv("SHUTDOWN_GRACE_PERIOD"), 10, 32)
+	if shutdownGracePeriod <= 0 {
+		// Heroku kills the process 30 seconds after sending SIGTERM
+		shutdownGracePeriod = 25
+	}
+	fetchSchedule = os.Getenv("FETCH_SCHEDULE")
+	sendSchedule = os.Getenv("SEND_SCHEDULE")
+	cleanupSchedule = os.Getenv("CLEANUP_SCHEDULE")
+	pollMinInterval, _ = strconv.ParseInt(os.Getenv("POLL_MIN_INTERVAL"), 10, 32)
+	pollMaxInterval, _ = strconv.ParseInt(os.Getenv("POLL_MAX_INTERVAL"), 10, 32)
+	if pollMaxInterval <= 0 {
+		// Quiet repositories are polled at least as often as they were with the ticker
+		pollMaxInterval = tickerTime * 60
+	}
+	githubTokens = strings.Split(os.Getenv("GITHUB_TOKENS"), ",")
+	githubEnterpriseTokens = parseTokens(os.Getenv("GITHUB_ENTERPRISE_TOKENS"))
+	githubFetcher = os.Getenv("GITHUB_FETCHER")
+	githubGraphQLBatchSize, _ = strconv.ParseInt(os.Getenv("GITHUB_GRAPHQL_BATCH_SIZE"), 10, 32)
+	maxPagesPerRun, _ = strconv.ParseInt(os.Getenv("MAX_PAGES_PER_RUN"), 10, 32)
+	githubAppID, _ = strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64)
+	// Allows the PEM private key to be set on a single line with escaped new lines
+	githubAppPrivateKey = strings.ReplaceAll(os.Getenv("GITHUB_APP_PRIVATE_KEY"), `\n`, "\n")
+	githubMaxWait, _ = strconv.ParseInt(os.Getenv("GITHUB_MAX_WAIT"), 10, 32)
+	gitlabBaseURL = os.Getenv("GITLAB_BASE_URL")
+	gitlabToken = os.Getenv("GITLAB_TOKEN")
+	giteaTokens = parseTokens(os.Getenv("GITEA_TOKENS"))
+	port = os.Getenv("PORT")
+	githubWebhookSecret = os.Getenv("GITHUB_WEBHOOK_SECRET")
+	webhookCoverageWindow, _ = strconv.ParseInt(os.Getenv("WEBHOOK_COVERAGE"), 10, 32)
+	if webhookCoverageWindow <= 0 {
+		webhookCoverageWindow = 24
+	}
 
 	utils.InitLogging(environment)
 
+	if gitlabBaseURL != "" {
+		services.SetDefaultBaseURL(services.ProviderGitLab, gitlabBaseURL)
+	}
+
+	// Each GitHub Enterprise Server host gets its own client as its tokens and rate limits are separate
+	githubClients = map[string]*github.Client{
+		"github.com": github.NewClient(github.DefaultBaseURL, githubTokens, time.Duration(githubMaxWait)*time.Minute),
+	}
+	if githubAppID != 0 {
+		app, err := github.NewApp(github.DefaultBaseURL, githubAppID, []byte(githubAppPrivateKey))
+		if err != nil {
+			utils.LogError.Fatalln("Failed to initialize GitHub App authentication. Error:", err)
+		}
+		githubClients["github.com"] = github.NewAppClient(app, githubTokens, time.Duration(githubMaxWait)*time.Minute)
+		utils.LogInfo.Println("Authenticating with GitHub as GitHub App:", githubAppID)
+	}
+	for host, tokens := range githubEnterpriseTokens {
+		githubClients[host] = github.NewClient(github.APIBaseURL("https://"+host), strings.Split(tokens, "|"), time.Duration(githubMaxWait)*time.Minute)
+	}
+
+	sources.Register(services.ProviderGitHub, sources.NewGitHubSource(githubClients, int(maxPagesPerRun)))
+	githubGraphQL = sources.NewGitHubGraphQL(githubClients, int(githubGraphQLBatchSize))
+	sources.Register(services.ProviderGitLab, sources.NewGitLabSource(gitlabToken))
+	giteaSource := sources.NewGiteaSource(giteaTokens)
+	sources.Register(services.ProviderGitea, giteaSource)
+	sources.Register(services.ProviderForgejo, giteaSource)
+
 	services.Init(issueNotifierAPIEndpoint)
 
 	database.Init(environment, dbUser, dbPass, dbName, dbURL)
 	defer database.DB.Close()
+	database.Migrate()
 
-	ticker := time.NewTicker(time.Duration(tickerTime) * time.Hour)
+	ctx := shutdownContext()
 
-	for range ticker.C {
-		utils.LogInfo.Println("Starting to grab issue events per repository")
-		start()
+	// `webhook` runs the service as an HTTP server receiving GitHub webhooks instead of polling
+	if len(os.Args) > 1 && os.Args[1] == "webhook" {
+		if githubWebhookSecret == "" {
+			utils.LogError.Fatalln("GITHUB_WEBHOOK_SECRET must be set to receive GitHub webhooks")
+		}
+		serveWebhooks(ctx)
+		return
 	}
-}
 
-func start() {
-	repositories, err := services.GetAllRepositories()
-	if err != nil {
-		utils.LogError.Println("Failed to get all repositories. Error:", err)
+	// `backfill` replays the issue events of a repository for a past window and exits
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		err := backfill(ctx, os.Args[2:])
+		if err != nil {
+			utils.LogError.Fatalln("Failed to backfill. Error:", err)
+		}
 		return
 	}
-	utils.LogInfo.Println("Got", len(repositories), "repositories")
 
-	for _, repository := range repositories {
-		go processIssueEvents(repository)
+	// Each job runs on its own cron schedule instead of all of them one after the other every tick
+	var jobs []cron.Job
+	if fetchSchedule != "" || sendSchedule != "" || cleanupSchedule != "" {
+		jobs, err = scheduledJobs()
+		if err != nil {
+			utils.LogError.Fatalln("Failed to schedule jobs. Error:", err)
+		}
 	}
 
-	time.Sleep(time.Duration(timeGap) * time.Minute)
-
-	users, err := models.GetAllUsersWithPendingNotificationData()
-	if err != nil {
-		utils.LogError.Println("Failed to get all users with pending notification data. Error:", err)
-		return
+	// The fetches and deliveries enqueued by the leader are run by the workers of every replica
+	if queueWorkers > 0 {
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			runQueueWorkers(ctx, databaseJobQueue{}, handleQueuedJob, int(queueWorkers), time.Duration(queueVisibility)*time.Minute)
+		}()
 	}
-	utils.LogInfo.Println("Got", len(users), "users with pending notification data")
 
-	for _, user := range users {
-		go sendEmail(user)
+	// Only the leader replica schedules the jobs, the others stand by to take over if it dies
+	database.RunAsLeader(ctx, database.LeaderLockKey, leaderCheckInterval, func(ctx context.Context) {
+		runScheduler(ctx, jobs)
+	})
+
+	// No new work is started anymore, the sends and database writes in progress are given the grace
+	// period to finish before the database connections are closed
+	utils.LogInfo.Println("Waiting for the work in progress to finish")
+	if !waitForInFlight(time.Duration(shutdownGracePeriod) * time.Second) {
+		utils.LogError.Println("Work in progress did not finish within the grace period of", shutdownGracePeriod, "seconds")
 	}
+	utils.LogInfo.Println("Shut down")
+}
 
-	time.Sleep(time.Duration(timeGap) * time.Minute)
+// shutdownContext returns a context which is cancelled once the process receives SIGTERM or SIGINT,
+// which cancels the fetches in progress and stops new work from being scheduled
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	err = models.DeleteAllSentNotificationData()
-	if err != nil {
-		utils.LogError.Println("Failed to deleted all notification data with `sent` status equal to `true`. Error:", err)
-		return
-	}
-	utils.LogInfo.Println("Successfully deleted all notification data with `sent` status equal to `true`")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		utils.LogInfo.Println("Received signal:", sig, ", shutting down")
+		cancel()
+	}()
+
+	return ctx
 }
 
-func processIssueEvents(repository services.Repository) {
-	utils.LogInfo.Println("Processing issue events for repository:", repository.RepoName)
+// parseTokens parses a comma separated list of `key=token` pairs
+func parseTokens(value string) map[string]string {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			tokens[kv[0]] = kv[1]
+		}
+	}
 
-	subscriptionsByRepoID, err := services.GetSubscriptionsByRepoID(repository.RepoID)
-	if err != nil {
-		utils.LogError.Println("Failed to get subscriptions for repository:", repository.RepoName, ". Error:", err)
-		return
+	return tokens
+}
+
+// parseConcurrency parses the maximum number of tasks a stage runs at a time, 10 by default
+func parseConcurrency(value string) int64 {
+	concurrency, _ := strconv.ParseInt(value, 10, 32)
+	if concurrency <= 0 {
+		return 10
 	}
-	utils.LogInfo.Println("Got", len(subscriptionsByRepoID), "subscriptions for repository:", repository.RepoName)
 
-	// Used to map users per label to get their interest
-	userLabelSet := make(map[string]map[uuid.UUID]bool, len(subscriptionsByRepoID))
-	// Used to store list of users who are interested for this label
-	usersPerLabelMap := make(map[string][]uuid.UUID, len(subscriptionsByRepoID))
-	// Used to store list of issues which contain this particular label
-	issuesPerLabelMap := make(map[string][]float64, len(subscriptionsByRepoID))
+	return concurrency
+}
 
-	for _, sl := range subscriptionsByRepoID {
-		labelName := sl["label"].(string)
-		userID, _ := uuid.Parse(sl["userID"].(string))
+// fetchOutcome is what processIssueEvents reports about a repository, to schedule its next fetch and
+// record it in the run history
+type fetchOutcome struct {
+	// events is the number of new issue events
+	events int
+	// subscriptions is the number of label subscriptions of the repository
+	subscriptions int
+	// issues is the number of matched issues
+	issues int
+	// notifications is the number of notification data created for the matched issues
+	notifications int
+}
 
-		if _, exists := usersPerLabelMap[labelName]; exists {
-			usersPerLabelMap[labelName] = append(usersPerLabelMap[labelName], userID)
-		} else {
-			usersPerLabelMap[labelName] = []uuid.UUID{userID}
-			userLabelSet[labelName] = make(map[uuid.UUID]bool)
-		}
+// processIssueEvents matches the issue events of the repository since its cursor against its
+// subscriptions. If the events were already fetched in a batch with other repositories they are
+// passed as prefetched, otherwise they are fetched from the source of the repository.
+func processIssueEvents(ctx context.Context, repository services.Repository, prefetched *sources.EventList) (fetchOutcome, error) {
+	utils.LogInfo.Println("Processing issue events for repository:", repository.RepoName)
 
-		userLabelSet[labelName][userID] = true
+	matcher, err := newIssueMatcher(repository)
+	if err != nil {
+		return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: failed to get subscriptions: %v", err)
 	}
+	outcome := fetchOutcome{subscriptions: matcher.subscriptions}
 
-	var fetchEventsFrom time.Time
-	if repository.LastEventAt.Equal(BaseTime) {
-		fetchEventsFrom = time.Now().AddDate(0, 0, -1)
-	} else {
-		fetchEventsFrom = repository.LastEventAt
+	cursor, err := loadCursor(repository)
+	if err != nil {
+		return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: %v", err)
 	}
-	utils.LogInfo.Println("Fetch events from:", fetchEventsFrom, "for repository:", repository.RepoName)
+	utils.LogInfo.Println("Fetch events from:", cursor.Since, "after event:", cursor.EventID, "for repository:", repository.RepoName)
 
-	var events []map[string]interface{}
-	httpClient := &http.Client{}
-	pageNumber := 1
-	var oldestEventTime time.Time
-	var mostRecentEventTime time.Time
-	for {
-		// TODO: Think of Authorization as it can be a blocker once no. of repositories increases or use Etag maybe?
-		req, _ := http.NewRequest("GET", "https://api.github.com/repos/"+repository.RepoName+"/issues/events?page="+strconv.Itoa(pageNumber)+"&per_page=100", nil)
-		res, err := httpClient.Do(req)
-
-		if err != nil {
-			utils.LogError.Println("Failed to fetch issue events for repository:", repository.RepoName, "from GitHub. Error:", err)
-			return
+	// The events of repositories covered by webhooks are matched as they are delivered, only the ones
+	// from before the webhooks took over are polled
+	webhook, covered, err := webhookCoverage(repository)
+	if err != nil {
+		return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: %v", err)
+	}
+	if covered && !cursor.Since.Before(webhook.CoveredSince) {
+		utils.LogInfo.Println("Issue events are delivered by webhooks for repository:", repository.RepoName)
+		if webhook.LastDeliveryAt.After(cursor.Since) {
+			// Polling resumes from the last delivery if the webhooks stop
+			err = saveCursor(repository, sources.Cursor{Since: webhook.LastDeliveryAt, ETag: cursor.ETag, LastModified: cursor.LastModified})
+			if err != nil {
+				return outcome, fmt.Errorf("[processIssueEvents]: %v", err)
+			}
 		}
+		return outcome, nil
+	}
 
-		defer res.Body.Close()
-
-		dataBytes, _ := ioutil.ReadAll(res.Body)
-		if res.StatusCode != http.StatusOK {
-			utils.LogError.Println("Failed to fetch issue events for repository:", repository.RepoName, "from GitHub. Got response status of:", res.Status, "with message:", string(dataBytes))
-			return
+	list := prefetched
+	if list == nil {
+		source, err := sources.For(repository)
+		if err != nil {
+			return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: %v", err)
 		}
-		var data []map[string]interface{}
-
-		json.Unmarshal(dataBytes, &data)
-		events = append(events, data...)
-		oldestEventTime, _ = time.Parse(Layout2, data[len(data)-1]["created_at"].(string))
 
-		if pageNumber == 1 {
-			mostRecentEventTime, _ = time.Parse(Layout2, data[0]["created_at"].(string))
+		list, err = source.ListEvents(ctx, repository, cursor)
+		if _, ok := err.(*github.RateLimitError); ok {
+			// Leave the cursor untouched so the next run picks up from where this one stopped
+			return outcome, fmt.Errorf("[processIssueEvents]: rescheduled to a later run: %w", err)
 		}
-
-		if oldestEventTime.Before(fetchEventsFrom) {
-			break
+		if err != nil {
+			return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: failed to fetch issue events: %v", err)
 		}
+	}
 
-		pageNumber++
+	if list.NotModified {
+		utils.LogInfo.Println("No new issue events since the last run for repository:", repository.RepoName)
+		return outcome, nil
+	}
+	utils.LogInfo.Println("Issue events for repository:", repository.RepoName, list.Stats)
+	if list.Truncated {
+		utils.LogError.Println("Issue events window got truncated for repository:", repository.RepoName, ". Events between:", cursor.Since, "and the oldest fetched event may have been missed")
 	}
-	utils.LogInfo.Println("Paginated up to:", pageNumber, "pages. Fetched events from:", oldestEventTime, "to:", mostRecentEventTime, "for repository:", repository.RepoName)
 
-	issues := make(map[float64]models.Issue, len(events))
-	for i := len(events) - 1; i >= 0; i-- {
-		e := events[i]
-		if e["issue"] == nil {
-			utils.LogInfo.Println("No issue JSON found for event:", e["id"].(float64), "for repository:", repository.RepoName)
-			return
+	if covered {
+		list.Events = eventsBefore(list.Events, webhook.CoveredSince)
+		if list.Next.Since.After(webhook.CoveredSince) {
+			list.Next.Since = webhook.CoveredSince
+			list.Next.EventID = 0
 		}
+	}
 
-		eventTime, _ := time.Parse(Layout2, e["created_at"].(string))
-		if eventTime.Before(fetchEventsFrom) {
-			continue
-		}
+	if list.Next.Since.Equal(cursor.Since) && list.Next.EventID == cursor.EventID && len(list.Events) == 0 {
+		utils.LogInfo.Println("No issue events found for repository:", repository.RepoName)
+		// The cursor did not move but the validators did, keep them so the next run is a conditional request
+		updateRepositoryETag(repository, list.Next.ETag, list.Next.LastModified)
+		return outcome, nil
+	}
 
-		eventType := e["event"].(string)
-		issueState := e["issue"].(map[string]interface{})["state"].(string)
-		if eventType == "labeled" && issueState != "closed" {
-			issueNumber := e["issue"].(map[string]interface{})["number"].(float64)
-
-			labelName := e["label"].(map[string]interface{})["name"].(string)
-			if _, isLabelOfInterest := usersPerLabelMap[labelName]; isLabelOfInterest {
-				labelsObject := e["issue"].(map[string]interface{})["labels"].([]interface{})
-				var labels []services.Label
-				for _, l := range labelsObject {
-					label := services.Label{
-						Name:  l.(map[string]interface{})["name"].(string),
-						Color: "#" + l.(map[string]interface{})["color"].(string),
-					}
-
-					labels = append(labels, label)
-				}
-
-				if len(labels) == 0 {
-					utils.LogInfo.Println("Issue number:", issueNumber, "has event type of labelled but has an empty labels data")
-					continue
-				}
-
-				issues[issueNumber] = models.Issue{
-					Number:         issueNumber,
-					Title:          e["issue"].(map[string]interface{})["title"].(string),
-					State:          issueState,
-					Labels:         labels,
-					CreatedAt:      e["issue"].(map[string]interface{})["created_at"].(string),
-					UpdatedAt:      e["issue"].(map[string]interface{})["updated_at"].(string),
-					AssigneesCount: len(e["issue"].(map[string]interface{})["assignees"].([]interface{})),
-				}
-
-				if _, exists := issuesPerLabelMap[labelName]; exists {
-					issuesPerLabelMap[labelName] = append(issuesPerLabelMap[labelName], issueNumber)
-				} else {
-					issuesPerLabelMap[labelName] = []float64{issueNumber}
-				}
+	// Events saved by a run which died before updating the cursor are fetched again
+	issueEvents, err := matcher.filterProcessed(list.Events)
+	if err != nil {
+		return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: %v", err)
+	}
 
-			}
-		}
+	for _, e := range issueEvents {
+		matcher.match(e)
 	}
-	utils.LogInfo.Println("Got", len(issues), "issue events for repository:", repository.RepoName)
+	outcome.events = len(issueEvents)
 
-	// If no issue events of interest found then return
-	if len(issues) == 0 {
-		err := services.UpdateLastEventAt(repository.RepoID, mostRecentEventTime)
-		if err != nil {
-			utils.LogError.Println("Failed to update `lastEventAt` time for repository:", repository.RepoName, ". Error:", err)
-			return
-		}
-		utils.LogInfo.Println("Updated `lastEventAt` time to:", mostRecentEventTime, "for repository:", repository.RepoName)
-		return
+	err = matcher.save()
+	if err != nil {
+		return fetchOutcome{}, fmt.Errorf("[processIssueEvents]: failed to save notification data: %v", err)
 	}
+	outcome.issues = len(matcher.issues)
+	outcome.notifications = matcher.notifications()
 
-	issuesPerUserMap := make(map[uuid.UUID][]float64, len(issues))
-	for labelName, users := range usersPerLabelMap {
-		if len(issuesPerLabelMap[labelName]) > 0 {
-			for _, user := range users {
-				if _, exists := issuesPerUserMap[user]; exists {
+	err = saveCursor(repository, list.Next)
+	if err != nil {
+		return outcome, fmt.Errorf("[processIssueEvents]: %v", err)
+	}
+	utils.LogInfo.Println("Updated cursor to:", list.Next.Since, "event:", list.Next.EventID, "for repository:", repository.RepoName)
 
-					issuesPerUserMap[user] = append(issuesPerUserMap[user], issuesPerLabelMap[labelName]...)
-				} else {
-					issuesPerUserMap[user] = issuesPerLabelMap[labelName]
-				}
-			}
-		}
+	return outcome, nil
+}
+
+// loadCursor returns the position up to which the issue events of the repository have been processed.
+// Repositories which were never fetched start from the events of the last 24 hours.
+func loadCursor(repository services.Repository) (sources.Cursor, error) {
+	stored, found, err := models.GetRepositoryCursor(repository.RepoID)
+	if err != nil {
+		return sources.Cursor{}, fmt.Errorf("[loadCursor]: %v", err)
 	}
 
-	issueDataPerUserMap := make(map[uuid.UUID]map[float64]models.Issue, len(issues))
-	for userID, userIssues := range issuesPerUserMap {
-		issueDataPerUserMap[userID] = getIssuesWithData(userID, userIssues, issues, userLabelSet)
+	var cursor sources.Cursor
+	if found {
+		cursor.Since = stored.LastEventAt
+		cursor.EventID = stored.LastEventID
+	} else if !repository.LastEventAt.Equal(BaseTime) {
+		// Repositories fetched before cursors were stored only have the `lastEventAt` of the API
+		cursor.Since = repository.LastEventAt
+	} else {
+		cursor.Since = time.Now().AddDate(0, 0, -1)
 	}
 
-	err = models.CreateBulkNotificationsByRepoID(repository.RepoID, issueDataPerUserMap)
+	cachedETag, err := models.GetRepositoryETag(repository.RepoID)
 	if err != nil {
-		utils.LogError.Println("Failed to save notification data for repository:", repository.RepoName, ". Error:", err)
-		return
+		utils.LogError.Println("Failed to get cached ETag for repository:", repository.RepoName, ". Error:", err)
+	}
+	cursor.ETag = cachedETag.ETag
+	cursor.LastModified = cachedETag.LastModified
+
+	return cursor, nil
+}
+
+// saveCursor persists the cursor once all the events up to it have been processed. The API keeps
+// its `lastEventAt` too as it is shown in the emails.
+func saveCursor(repository services.Repository, cursor sources.Cursor) error {
+	err := models.UpsertRepositoryCursor(repository.RepoID, cursor.EventID, cursor.Since)
+	if err != nil {
+		return fmt.Errorf("[saveCursor]: %v", err)
 	}
 
-	err = services.UpdateLastEventAt(repository.RepoID, mostRecentEventTime)
+	err = services.UpdateLastEventAt(repository.RepoID, cursor.Since)
 	if err != nil {
-		utils.LogError.Println("Failed to update `lastEventAt` time for repository:", repository.RepoName, ". Error:", err)
+		return fmt.Errorf("[saveCursor]: %v", err)
 	}
-	utils.LogInfo.Println("Updated `lastEventAt` time to:", mostRecentEventTime, "for repository:", repository.RepoName)
-}
 
-func getIssuesWithData(userID uuid.UUID, userIssues []float64, issues map[float64]models.Issue, userLabelSet map[string]map[uuid.UUID]bool) map[float64]models.Issue {
-	data := make(map[float64]models.Issue, len(userIssues))
-	for _, ui := range userIssues {
-		if _, exists := data[ui]; !exists {
+	updateRepositoryETag(repository, cursor.ETag, cursor.LastModified)
+	return nil
+}
 
-			issueData := issues[ui]
-			for li, la := range issueData.Labels {
-				issueData.Labels[li].IsOfInterest = userLabelSet[la.Name][userID]
-			}
+// prefetchWithGraphQL fetches the issue events of the GitHub repositories in batches with the GraphQL
+// API. The repositories missing from the result are left to be fetched one by one.
+func prefetchWithGraphQL(ctx context.Context, repositories []services.Repository) map[uuid.UUID]*sources.EventList {
+	var githubRepositories []services.Repository
+	cursors := make(map[uuid.UUID]sources.Cursor)
+	for _, repository := range repositories {
+		if repository.Provider != services.ProviderGitHub {
+			continue
+		}
 
-			data[ui] = issueData
+		cursor, err := loadCursor(repository)
+		if err != nil {
+			utils.LogError.Println("Failed to load cursor for repository:", repository.RepoName, ". Error:", err)
+			continue
 		}
+		githubRepositories = append(githubRepositories, repository)
+		cursors[repository.RepoID] = cursor
+	}
+
+	lists := githubGraphQL.ListEventsBatch(ctx, githubRepositories, cursors)
+	utils.LogInfo.Println("Fetched issue events of", len(lists), "out of", len(githubRepositories), "GitHub repositories with GraphQL")
+
+	return lists
+}
+
+// updateRepositoryETag caches the validators of the first events page. It must only be called once
+// all the events have been processed, otherwise a failed run would be skipped with a `304` next time.
+func updateRepositoryETag(repository services.Repository, etag, lastModified string) {
+	if etag == "" && lastModified == "" {
+		return
 	}
 
-	return data
+	err := models.UpsertRepositoryETag(repository.RepoID, etag, lastModified)
+	if err != nil {
+		utils.LogError.Println("Failed to cache ETag for repository:", repository.RepoName, ". Error:", err)
+	}
 }
 
-func sendEmail(user models.User) {
+// errSentNotRecorded is returned when an email was sent but could not be recorded as sent, in which
+// case sending it again would send a duplicate
+var errSentNotRecorded = errors.New("email sent but not recorded as sent")
+
+// sendEmail sends the digest of the pending notification data of the user, marks it as sent and returns
+// the number of issues it contained
+func sendEmail(user models.User, repositoriesByID map[string]services.Repository) (int, error) {
 	// smtp server configuration.
 	smtpHost := "smtp.gmail.com"
 	smtpPort := "587"
 
 	issuesPerRepositoryMap, err := models.GetAllPendingNotificationDataByUserID(user.UserID)
 	if err != nil {
-		utils.LogError.Println("Failed to get notification data for user:", user.UserID, ". Error:", err)
-		return
+		return 0, fmt.Errorf("[sendEmail]: failed to get notification data: %v", err)
 	}
 
 	var repositories []repositoryData
+	issues := 0
 	for repoName, repoData := range issuesPerRepositoryMap {
 		lastEventAt := repoData.(map[string]interface{})["lastEventAt"].(time.Time).Format(Layout3)
 		issueDataArr := repoData.(map[string]interface{})["issues"].([]models.Issue)
+		repository, exists := repositoriesByID[repoData.(map[string]interface{})["repoID"].(string)]
+		if !exists {
+			repository = services.Repository{RepoName: repoName, Provider: services.ProviderGitHub, BaseURL: services.DefaultBaseURL(services.ProviderGitHub)}
+		}
 		repositories = append(repositories, repositoryData{
 			RepoName:    repoName,
 			LastEventAt: lastEventAt,
 			Issues:      issueDataArr,
+			Repository:  repository,
 		})
+		issues += len(issueDataArr)
 		utils.LogInfo.Println("Got", len(issueDataArr), "issues for repository:", repoName)
 	}
 
@@ -356,8 +542,7 @@ func sendEmail(user models.User) {
 	templateFilePath := "./email_templates/new_labeled_events.html"
 	t, err := template.ParseFiles(templateFilePath)
 	if err != nil {
-		utils.LogError.Println("Failed to parse template file:", templateFilePath, ". Error:", err)
-		return
+		return issues, fmt.Errorf("[sendEmail]: failed to parse template file %v: %v", templateFilePath, err)
 	}
 
 	var body bytes.Buffer
@@ -369,18 +554,29 @@ func sendEmail(user models.User) {
 	// Sending email.
 	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, gmailID, []string{user.Email}, body.Bytes())
 	if err != nil {
-		utils.LogError.Println("Failed to send email to user:", user.UserID, ". Error:", err)
-		return
+		return issues, fmt.Errorf("[sendEmail]: failed to send email: %v", err)
 	}
 	utils.LogInfo.Println("Successfully sent email to user:", user.UserID)
 
+	failed := 0
 	for repoName, repoData := range issuesPerRepositoryMap {
-		err := models.UpdateSentNotificationData(user.UserID.String(), repoData.(map[string]interface{})["repoID"].(string))
+		// Only the issues of the email, notification data created since it was built are left pending
+		issueNumbers := make([]float64, 0)
+		for _, issue := range repoData.(map[string]interface{})["issues"].([]models.Issue) {
+			issueNumbers = append(issueNumbers, issue.Number)
+		}
+
+		err := models.UpdateSentNotificationData(user.UserID.String(), repoData.(map[string]interface{})["repoID"].(string), issueNumbers)
 		if err != nil {
-			utils.LogError.Println("Failed to update `sent` status to `true` for all notification data for user:", user.UserID, " and repository:", repoName, ". Error:", err)
+			utils.LogError.Println("Failed to update `sent` status to `true` for the emailed notification data for user:", user.UserID, " and repository:", repoName, ". Error:", err)
+			failed++
 			continue
 		}
-		utils.LogInfo.Println("Updated `sent` status to `true` for all notification data for user:", user.UserID, " and repository:", repoName)
+		utils.LogInfo.Println("Updated `sent` status to `true` for the emailed notification data for user:", user.UserID, " and repository:", repoName)
+	}
+	if failed > 0 {
+		return issues, fmt.Errorf("[sendEmail]: failed to update `sent` status of %v repositories: %w", failed, errSentNotRecorded)
 	}
 
+	return issues, nil
 }
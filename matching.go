@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/sources"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// issueMatcher matches issue events of a repository against the subscription rules of its users
+// and saves the matched issues as notification data for each interested user
+type issueMatcher struct {
+	repository services.Repository
+	// subscriptions is the number of label subscriptions of the repository
+	subscriptions int
+
+	// Used to match the label names against the subscribed patterns, the maps below are keyed by pattern
+	labels *labelMatcher
+	// Used to store the subscription rules of the users
+	rules []subscriptionRule
+	// Used to store the indexes of the rules of each user
+	rulesPerUserMap map[uuid.UUID][]int
+	// Used to store the indexes of the rules referring to this label pattern, whatever its role
+	rulesPerLabelMap map[string][]int
+	// Used to map users per required or any-of label pattern to get their interest
+	userLabelSet map[string]map[uuid.UUID]bool
+	// Used to store the users to notify of each issue
+	usersPerIssueMap map[float64]map[uuid.UUID]bool
+	// Used to store the issues of interest by their issue number
+	issues map[float64]models.Issue
+
+	// Used to store issues whose pending notifications must be retracted for all users
+	retractedIssues map[float64]bool
+	// Used to store issues whose pending notifications must be retracted for some users only
+	retractedUsersPerIssueMap map[float64]map[uuid.UUID]bool
+
+	// Used to store the IDs of the matched events, recorded as processed along with the notification data
+	eventIDs []int64
+}
+
+// newIssueMatcher gets the subscriptions of the given repository and builds an issueMatcher for it
+func newIssueMatcher(repository services.Repository) (*issueMatcher, error) {
+	subscriptionsByRepoID, err := services.GetSubscriptionsByRepoID(repository.RepoID)
+	if err != nil {
+		return nil, fmt.Errorf("[newIssueMatcher]: %v", err)
+	}
+	utils.LogInfo.Println("Got", len(subscriptionsByRepoID), "subscriptions for repository:", repository.RepoName)
+
+	return buildIssueMatcher(repository, subscriptionsByRepoID), nil
+}
+
+// buildIssueMatcher builds an issueMatcher for the given subscriptions of the repository. Invalid
+// subscriptions are logged and skipped.
+func buildIssueMatcher(repository services.Repository, subscriptionsByRepoID []map[string]interface{}) *issueMatcher {
+	m := &issueMatcher{
+		repository:       repository,
+		subscriptions:    len(subscriptionsByRepoID),
+		labels:           newLabelMatcher(),
+		rulesPerUserMap:  make(map[uuid.UUID][]int, len(subscriptionsByRepoID)),
+		rulesPerLabelMap: make(map[string][]int, len(subscriptionsByRepoID)),
+		userLabelSet:     make(map[string]map[uuid.UUID]bool, len(subscriptionsByRepoID)),
+		usersPerIssueMap: make(map[float64]map[uuid.UUID]bool),
+		issues:           make(map[float64]models.Issue),
+
+		retractedIssues:           make(map[float64]bool),
+		retractedUsersPerIssueMap: make(map[float64]map[uuid.UUID]bool),
+	}
+
+	for _, subscription := range subscriptionsByRepoID {
+		rule, err := newSubscriptionRule(subscription, m.labels)
+		if err != nil {
+			utils.LogError.Println("Skipping subscription to label:", subscription["label"], "of repository:", repository.RepoName, ". Error:", err)
+			continue
+		}
+
+		i := len(m.rules)
+		m.rules = append(m.rules, rule)
+		m.rulesPerUserMap[rule.userID] = append(m.rulesPerUserMap[rule.userID], i)
+		for _, labelName := range rule.patterns() {
+			m.rulesPerLabelMap[labelName] = append(m.rulesPerLabelMap[labelName], i)
+		}
+
+		for _, labelName := range append(append([]string(nil), rule.required...), rule.anyOf...) {
+			if _, exists := m.userLabelSet[labelName]; !exists {
+				m.userLabelSet[labelName] = make(map[uuid.UUID]bool)
+			}
+			m.userLabelSet[labelName][rule.userID] = true
+		}
+	}
+
+	return m
+}
+
+// sourceKey identifies the instance which issued the events of the repository, as event IDs are only
+// unique within it
+func sourceKey(repository services.Repository) string {
+	return repository.Provider + ":" + repository.Host()
+}
+
+// processedEventID returns the ID the event is recorded as processed with. It is derived from the
+// key of the event rather than its ID, which differs between webhook deliveries and the APIs.
+func (m *issueMatcher) processedEventID(e events.Event) int64 {
+	return sources.StableEventID(m.repository.RepoID.String() + ":" + e.Key())
+}
+
+// filterProcessed returns the events which were not processed by a previous run yet, whether they
+// were fetched or delivered by a webhook
+func (m *issueMatcher) filterProcessed(issueEvents []events.Event) ([]events.Event, error) {
+	eventIDs := make([]int64, 0, len(issueEvents))
+	for _, e := range issueEvents {
+		eventIDs = append(eventIDs, m.processedEventID(e))
+	}
+
+	processed, err := models.GetProcessedEventIDs(sourceKey(m.repository), eventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("[filterProcessed]: %v", err)
+	}
+	if len(processed) == 0 {
+		return issueEvents, nil
+	}
+
+	remaining := make([]events.Event, 0, len(issueEvents)-len(processed))
+	for _, e := range issueEvents {
+		if !processed[m.processedEventID(e)] {
+			remaining = append(remaining, e)
+		}
+	}
+	utils.LogInfo.Println("Skipping", len(issueEvents)-len(remaining), "already processed issue events for repository:", m.repository.RepoName)
+
+	return remaining, nil
+}
+
+// match records the issue of the given event for the users whose subscription rule it newly matches
+// once it got labeled, unlabeled or unassigned, or retracts it for the users whose rules it no longer
+// matches. Issues which got closed, assigned or transferred are retracted for all users.
+// Events must be validated and matched in the order they happened.
+func (m *issueMatcher) match(e events.Event) {
+	m.eventIDs = append(m.eventIDs, m.processedEventID(e))
+
+	switch e.Event {
+	case "labeled":
+		if e.Issue.State == "closed" {
+			return
+		}
+		m.matchLabelChange(e, true)
+
+	case "unlabeled":
+		m.matchLabelChange(e, false)
+
+	case "unassigned":
+		m.matchUnassigned(e)
+
+	case "closed", "assigned", "transferred":
+		m.retractIssue(float64(e.Issue.Number))
+	}
+}
+
+// matchLabelChange evaluates the rules of the users referring to the added or removed label against
+// the full label set of the issue. An issue is recorded for a user once a label one of their rules
+// wants got added, or a label it excludes got removed, and is retracted once none of their rules
+// matches it anymore.
+func (m *issueMatcher) matchLabelChange(e events.Event, added bool) {
+	issueNumber := float64(e.Issue.Number)
+
+	users := make(map[uuid.UUID]bool)
+	for _, labelName := range m.labels.match(e.Label.Name) {
+		for _, i := range m.rulesPerLabelMap[labelName] {
+			users[m.rules[i].userID] = true
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	if added && len(e.Issue.Labels) == 0 {
+		utils.LogInfo.Println("Issue number:", issueNumber, "has event type of labelled but has an empty labels data")
+		return
+	}
+
+	// The labels of the issue are its current ones, the changed label is added or removed so that they
+	// are the labels right after the event
+	labelNames := make([]string, 0, len(e.Issue.Labels)+1)
+	for _, l := range e.Issue.Labels {
+		if l.Name != e.Label.Name {
+			labelNames = append(labelNames, l.Name)
+		}
+	}
+	if added {
+		labelNames = append(labelNames, e.Label.Name)
+	}
+
+	for userID := range users {
+		matched, newlyMatched := false, false
+		for _, i := range m.rulesPerUserMap[userID] {
+			rule := m.rules[i]
+			if !rule.satisfiedBy(m.labels, labelNames, len(e.Issue.Assignees)) {
+				continue
+			}
+
+			matched = true
+			if (added && rule.wants(m.labels, e.Label.Name)) || (!added && rule.excludes(m.labels, e.Label.Name)) {
+				newlyMatched = true
+			}
+		}
+
+		switch {
+		case !matched:
+			m.retractUser(issueNumber, userID)
+		case newlyMatched && e.Issue.State != "closed":
+			m.recordUser(issueNumber, userID, e.Issue)
+		}
+	}
+}
+
+// matchUnassigned records the issue for every user whose rule matches it once nobody is assigned to it
+// anymore, as it needs someone to work on it again
+func (m *issueMatcher) matchUnassigned(e events.Event) {
+	if e.Issue.State == "closed" || len(e.Issue.Assignees) > 0 || len(e.Issue.Labels) == 0 {
+		return
+	}
+
+	labelNames := make([]string, 0, len(e.Issue.Labels))
+	for _, l := range e.Issue.Labels {
+		labelNames = append(labelNames, l.Name)
+	}
+
+	issueNumber := float64(e.Issue.Number)
+	for userID := range m.rulesPerUserMap {
+		if m.matchesUser(userID, labelNames, 0) {
+			m.recordUser(issueNumber, userID, e.Issue)
+		}
+	}
+}
+
+// matchesUser reports whether any rule of the user matches an issue carrying the given labels and
+// assigned to the given number of users
+func (m *issueMatcher) matchesUser(userID uuid.UUID, labelNames []string, assignees int) bool {
+	for _, i := range m.rulesPerUserMap[userID] {
+		if m.rules[i].satisfiedBy(m.labels, labelNames, assignees) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordUser records the issue for the user
+func (m *issueMatcher) recordUser(issueNumber float64, userID uuid.UUID, issue *events.Issue) {
+	m.issues[issueNumber] = newIssue(issue)
+	if _, exists := m.usersPerIssueMap[issueNumber]; !exists {
+		m.usersPerIssueMap[issueNumber] = make(map[uuid.UUID]bool)
+	}
+	m.usersPerIssueMap[issueNumber][userID] = true
+}
+
+// retractUser retracts the issue for the user only
+func (m *issueMatcher) retractUser(issueNumber float64, userID uuid.UUID) {
+	delete(m.usersPerIssueMap[issueNumber], userID)
+	if len(m.usersPerIssueMap[issueNumber]) == 0 {
+		delete(m.usersPerIssueMap, issueNumber)
+		delete(m.issues, issueNumber)
+	}
+
+	if _, exists := m.retractedUsersPerIssueMap[issueNumber]; !exists {
+		m.retractedUsersPerIssueMap[issueNumber] = make(map[uuid.UUID]bool)
+	}
+	m.retractedUsersPerIssueMap[issueNumber][userID] = true
+}
+
+// retractIssue retracts the issue for all users as it no longer needs anyone to work on it
+func (m *issueMatcher) retractIssue(issueNumber float64) {
+	delete(m.usersPerIssueMap, issueNumber)
+	delete(m.issues, issueNumber)
+
+	m.retractedIssues[issueNumber] = true
+}
+
+// save deletes the pending notification data of the retracted issues and stores the matched issues
+// as notification data for every user interested in them. Retractions are applied first as an issue
+// may have been retracted and matched again later in the same window.
+// The matched events are recorded as processed in the same transaction, so a run dying at any point
+// either saved everything or nothing and the events are never matched twice.
+func (m *issueMatcher) save() error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("[save]: %v", err)
+	}
+	defer tx.Rollback()
+
+	for issueNumber := range m.retractedIssues {
+		err := models.DeletePendingNotificationsByIssue(tx, m.repository.RepoID, issueNumber)
+		if err != nil {
+			return fmt.Errorf("[save]: %v", err)
+		}
+	}
+
+	for issueNumber, users := range m.retractedUsersPerIssueMap {
+		if m.retractedIssues[issueNumber] {
+			continue
+		}
+
+		userIDs := make([]uuid.UUID, 0, len(users))
+		for userID := range users {
+			userIDs = append(userIDs, userID)
+		}
+
+		err := models.DeletePendingNotificationsByIssueAndUsers(tx, m.repository.RepoID, issueNumber, userIDs)
+		if err != nil {
+			return fmt.Errorf("[save]: %v", err)
+		}
+	}
+
+	if len(m.retractedIssues) > 0 || len(m.retractedUsersPerIssueMap) > 0 {
+		utils.LogInfo.Println("Retracted", len(m.retractedIssues)+len(m.retractedUsersPerIssueMap), "issues for repository:", m.repository.RepoName)
+	}
+
+	utils.LogInfo.Println("Got", len(m.issues), "issue events for repository:", m.repository.RepoName)
+	if len(m.issues) > 0 {
+		err = models.CreateBulkNotificationsByRepoID(tx, m.repository.RepoID, m.issueDataPerUser())
+		if err != nil {
+			return fmt.Errorf("[save]: %v", err)
+		}
+	}
+
+	err = models.MarkEventsProcessed(tx, sourceKey(m.repository), m.repository.RepoID, m.eventIDs)
+	if err != nil {
+		return fmt.Errorf("[save]: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[save]: %v", err)
+	}
+
+	return nil
+}
+
+// report logs what save would store, without saving anything
+func (m *issueMatcher) report() {
+	for issueNumber := range m.retractedIssues {
+		utils.LogInfo.Println("Would retract issue:", issueNumber, "for all users of repository:", m.repository.RepoName)
+	}
+	for issueNumber, users := range m.retractedUsersPerIssueMap {
+		if !m.retractedIssues[issueNumber] {
+			utils.LogInfo.Println("Would retract issue:", issueNumber, "for", len(users), "users of repository:", m.repository.RepoName)
+		}
+	}
+
+	for userID, issues := range m.issueDataPerUser() {
+		issueNumbers := make([]float64, 0, len(issues))
+		for issueNumber := range issues {
+			issueNumbers = append(issueNumbers, issueNumber)
+		}
+		utils.LogInfo.Println("Would notify user:", userID, "of issues:", issueNumbers, "of repository:", m.repository.RepoName)
+	}
+}
+
+// notifications returns the number of notification data save creates, one per matched issue of each user
+func (m *issueMatcher) notifications() int {
+	count := 0
+	for _, issues := range m.issueDataPerUser() {
+		count += len(issues)
+	}
+
+	return count
+}
+
+// issueDataPerUser returns the matched issues of each user interested in them
+func (m *issueMatcher) issueDataPerUser() map[uuid.UUID]map[float64]models.Issue {
+	issuesPerUserMap := make(map[uuid.UUID][]float64, len(m.issues))
+	for issueNumber, users := range m.usersPerIssueMap {
+		for user := range users {
+			issuesPerUserMap[user] = append(issuesPerUserMap[user], issueNumber)
+		}
+	}
+
+	issueDataPerUserMap := make(map[uuid.UUID]map[float64]models.Issue, len(m.issues))
+	for userID, userIssues := range issuesPerUserMap {
+		issueDataPerUserMap[userID] = m.getIssuesWithData(userID, userIssues)
+	}
+
+	return issueDataPerUserMap
+}
+
+// patternOfInterest returns the pattern the user subscribed to which matches the label name, if any
+func (m *issueMatcher) patternOfInterest(userID uuid.UUID, labelName string) (string, bool) {
+	for _, key := range m.labels.match(labelName) {
+		if m.userLabelSet[key][userID] {
+			return m.labels.pattern(key), true
+		}
+	}
+
+	return "", false
+}
+
+func (m *issueMatcher) getIssuesWithData(userID uuid.UUID, userIssues []float64) map[float64]models.Issue {
+	data := make(map[float64]models.Issue, len(userIssues))
+	for _, ui := range userIssues {
+		if _, exists := data[ui]; !exists {
+
+			issueData := m.issues[ui]
+			// Copy the labels as the same issue is shared between users with different interests
+			issueData.Labels = append([]services.Label(nil), issueData.Labels...)
+			for li, la := range issueData.Labels {
+				// The pattern is shown in the email next to the labels it matched
+				issueData.Labels[li].Pattern, issueData.Labels[li].IsOfInterest = m.patternOfInterest(userID, la.Name)
+			}
+
+			data[ui] = issueData
+		}
+	}
+
+	return data
+}
+
+// newIssue converts an issue of the GitHub API to the issue data stored for the notifications
+func newIssue(issue *events.Issue) models.Issue {
+	labels := make([]services.Label, 0, len(issue.Labels))
+	for _, l := range issue.Labels {
+		labels = append(labels, services.Label{
+			Name:  l.Name,
+			Color: "#" + l.Color,
+		})
+	}
+
+	return models.Issue{
+		Number:         float64(issue.Number),
+		Title:          issue.Title,
+		State:          issue.State,
+		Labels:         labels,
+		CreatedAt:      issue.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      issue.UpdatedAt.Format(time.RFC3339),
+		AssigneesCount: len(issue.Assignees),
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/services"
+)
+
+const otherUserID = "7ca7b810-9dad-11d1-80b4-00c04fd430c8"
+
+// testIssue is the state of an issue when its events got fetched
+type testIssue struct {
+	number    int
+	closed    bool
+	labels    []string
+	assignees int
+}
+
+// event returns an event of the issue changing the given label, if any
+func (i testIssue) event(event, label string) events.Event {
+	issue := &events.Issue{Number: i.number, State: "open"}
+	if i.closed {
+		issue.State = "closed"
+	}
+	for _, name := range i.labels {
+		issue.Labels = append(issue.Labels, &events.Label{Name: name})
+	}
+	for a := 0; a < i.assignees; a++ {
+		issue.Assignees = append(issue.Assignees, &events.User{Login: "octocat"})
+	}
+
+	e := events.Event{Event: event, CreatedAt: time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC), Issue: issue}
+	if label != "" {
+		e.Label = &events.Label{Name: label}
+	}
+
+	return e
+}
+
+// matcherTest is the outcome of matching events against subscriptions
+type matcherTest struct {
+	name          string
+	subscriptions []map[string]interface{}
+	events        []events.Event
+	// wantRecorded are the users the issues get recorded for, wantRetracted the issues retracted for
+	// all users and wantRetractedUsers the users they get retracted for, by issue number
+	wantRecorded       map[int][]string
+	wantRetracted      []int
+	wantRetractedUsers map[int][]string
+}
+
+func (tt matcherTest) run(t *testing.T) {
+	t.Run(tt.name, func(t *testing.T) {
+		m := buildIssueMatcher(services.Repository{RepoID: uuid.New(), RepoName: "o/r"}, tt.subscriptions)
+		for _, e := range tt.events {
+			m.match(e)
+		}
+
+		checkUsers(t, "recorded", m.usersPerIssueMap, tt.wantRecorded)
+		checkUsers(t, "retracted users", m.retractedUsersPerIssueMap, tt.wantRetractedUsers)
+
+		if len(m.retractedIssues) != len(tt.wantRetracted) {
+			t.Errorf("retracted issues = %v, want %v", m.retractedIssues, tt.wantRetracted)
+		}
+		for _, number := range tt.wantRetracted {
+			if !m.retractedIssues[float64(number)] {
+				t.Errorf("retracted issues = %v, want %v", m.retractedIssues, tt.wantRetracted)
+			}
+		}
+	})
+}
+
+func checkUsers(t *testing.T, name string, got map[float64]map[uuid.UUID]bool, want map[int][]string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("%v = %v, want %v", name, got, want)
+		return
+	}
+	for number, userIDs := range want {
+		if len(got[float64(number)]) != len(userIDs) {
+			t.Errorf("%v of issue %d = %v, want %v", name, number, got[float64(number)], userIDs)
+			continue
+		}
+		for _, userID := range userIDs {
+			if !got[float64(number)][uuid.MustParse(userID)] {
+				t.Errorf("%v of issue %d = %v, want %v", name, number, got[float64(number)], userIDs)
+			}
+		}
+	}
+}
+
+func TestIssueMatcherRetraction(t *testing.T) {
+	bug := map[string]interface{}{"userID": testUserID, "label": "bug"}
+	feature := map[string]interface{}{"userID": otherUserID, "label": "feature"}
+	open := testIssue{number: 1, labels: []string{"bug", "feature"}}
+
+	for _, tt := range []matcherTest{
+		{
+			name:          "labeled issue is recorded",
+			subscriptions: []map[string]interface{}{bug, feature},
+			events:        []events.Event{open.event("labeled", "bug")},
+			wantRecorded:  map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "closed issue is retracted for all users",
+			subscriptions: []map[string]interface{}{bug, feature},
+			events:        []events.Event{open.event("labeled", "bug"), open.event("labeled", "feature"), open.event("closed", "")},
+			wantRetracted: []int{1},
+		},
+		{
+			name:          "assigned issue is retracted for all users",
+			subscriptions: []map[string]interface{}{bug},
+			events:        []events.Event{open.event("labeled", "bug"), open.event("assigned", "")},
+			wantRetracted: []int{1},
+		},
+		{
+			name:          "transferred issue is retracted for all users",
+			subscriptions: []map[string]interface{}{bug},
+			events:        []events.Event{open.event("transferred", "")},
+			wantRetracted: []int{1},
+		},
+		{
+			name:               "removed label retracts the issue for its subscribers only",
+			subscriptions:      []map[string]interface{}{bug, feature},
+			events:             []events.Event{testIssue{number: 1, labels: []string{"feature"}}.event("unlabeled", "bug")},
+			wantRetractedUsers: map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "label added to a closed issue is ignored",
+			subscriptions: []map[string]interface{}{bug},
+			events:        []events.Event{testIssue{number: 1, closed: true, labels: []string{"bug"}}.event("labeled", "bug")},
+		},
+		{
+			name:               "issue matched again after its retraction",
+			subscriptions:      []map[string]interface{}{bug},
+			events:             []events.Event{open.event("unlabeled", "bug"), open.event("labeled", "bug")},
+			wantRecorded:       map[int][]string{1: {testUserID}},
+			wantRetractedUsers: map[int][]string{1: {testUserID}},
+		},
+	} {
+		tt.run(t)
+	}
+}
+
+func TestIssueMatcherRules(t *testing.T) {
+	goBugs := map[string]interface{}{
+		"userID":         testUserID,
+		"requiredLabels": []interface{}{"bug", "go"},
+		"excludedLabels": []interface{}{"wontfix"},
+	}
+	anyOf := map[string]interface{}{"userID": otherUserID, "anyLabels": []interface{}{"docs", "feature"}}
+	subscriptions := []map[string]interface{}{goBugs, anyOf}
+
+	for _, tt := range []matcherTest{
+		{
+			name:          "issue carrying all the required labels is recorded",
+			subscriptions: subscriptions,
+			events:        []events.Event{testIssue{number: 1, labels: []string{"bug", "go"}}.event("labeled", "go")},
+			wantRecorded:  map[int][]string{1: {testUserID}},
+		},
+		{
+			name:               "issue missing a required label is not recorded",
+			subscriptions:      subscriptions,
+			events:             []events.Event{testIssue{number: 1, labels: []string{"bug"}}.event("labeled", "bug")},
+			wantRetractedUsers: map[int][]string{1: {testUserID}},
+		},
+		{
+			name:               "issue carrying an excluded label is not recorded",
+			subscriptions:      subscriptions,
+			events:             []events.Event{testIssue{number: 1, labels: []string{"bug", "go", "wontfix"}}.event("labeled", "go")},
+			wantRetractedUsers: map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "added excluded label retracts the issue",
+			subscriptions: subscriptions,
+			events: []events.Event{
+				testIssue{number: 1, labels: []string{"bug", "go"}}.event("labeled", "go"),
+				testIssue{number: 1, labels: []string{"bug", "go", "wontfix"}}.event("labeled", "wontfix"),
+			},
+			wantRetractedUsers: map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "removed excluded label records the issue",
+			subscriptions: subscriptions,
+			events:        []events.Event{testIssue{number: 1, labels: []string{"bug", "go"}}.event("unlabeled", "wontfix")},
+			wantRecorded:  map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "issue carrying any of the labels is recorded",
+			subscriptions: subscriptions,
+			events:        []events.Event{testIssue{number: 1, labels: []string{"feature"}}.event("labeled", "feature")},
+			wantRecorded:  map[int][]string{1: {otherUserID}},
+		},
+		{
+			name:          "label change only evaluates the rules referring to it",
+			subscriptions: subscriptions,
+			events:        []events.Event{testIssue{number: 1, labels: []string{"bug", "docs"}}.event("labeled", "docs")},
+			wantRecorded:  map[int][]string{1: {otherUserID}},
+		},
+	} {
+		tt.run(t)
+	}
+}
+
+func TestIssueMatcherUnassigned(t *testing.T) {
+	bug := map[string]interface{}{"userID": testUserID, "label": "bug"}
+	unassignedBug := map[string]interface{}{"userID": testUserID, "label": "bug", "unassignedOnly": true}
+	feature := map[string]interface{}{"userID": otherUserID, "label": "feature"}
+
+	for _, tt := range []matcherTest{
+		{
+			name:               "unassigned only rule does not match an assigned issue",
+			subscriptions:      []map[string]interface{}{unassignedBug},
+			events:             []events.Event{testIssue{number: 1, labels: []string{"bug"}, assignees: 1}.event("labeled", "bug")},
+			wantRetractedUsers: map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "rule matches an assigned issue unless unassigned only",
+			subscriptions: []map[string]interface{}{bug},
+			events:        []events.Event{testIssue{number: 1, labels: []string{"bug"}, assignees: 1}.event("labeled", "bug")},
+			wantRecorded:  map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "issue left without assignees is recorded for the matching users",
+			subscriptions: []map[string]interface{}{unassignedBug, feature},
+			events:        []events.Event{testIssue{number: 1, labels: []string{"bug"}}.event("unassigned", "")},
+			wantRecorded:  map[int][]string{1: {testUserID}},
+		},
+		{
+			name:          "issue still assigned to someone is not recorded",
+			subscriptions: []map[string]interface{}{bug},
+			events:        []events.Event{testIssue{number: 1, labels: []string{"bug"}, assignees: 1}.event("unassigned", "")},
+		},
+		{
+			name:          "closed issue is not recorded once unassigned",
+			subscriptions: []map[string]interface{}{bug},
+			events:        []events.Event{testIssue{number: 1, closed: true, labels: []string{"bug"}}.event("unassigned", "")},
+		},
+	} {
+		tt.run(t)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/issue-notifier/notification-service/database"
 	"github.com/issue-notifier/notification-service/services"
+	"github.com/lib/pq"
 )
 
 // Issue struct defines basic data each issue holds
@@ -79,7 +80,7 @@ func GetAllPendingNotificationDataByUserID(userID uuid.UUID) (map[string]interfa
 }
 
 // CreateBulkNotificationsByRepoID saves the given issueData (for each user) for the given repoID
-func CreateBulkNotificationsByRepoID(repoID uuid.UUID, issueDataPerUserMap map[uuid.UUID]map[float64]Issue) error {
+func CreateBulkNotificationsByRepoID(db database.Executor, repoID uuid.UUID, issueDataPerUserMap map[uuid.UUID]map[float64]Issue) error {
 	sqlQuery := `INSERT INTO NOTIFICATION_DATA (USER_ID, REPO_ID, ISSUE_NUMBER, ISSUE_DATA) VALUES `
 
 	valuesPlaceholder := make([]string, 0)
@@ -98,7 +99,7 @@ func CreateBulkNotificationsByRepoID(repoID uuid.UUID, issueDataPerUserMap map[u
 	}
 
 	sqlQuery = sqlQuery + strings.Join(valuesPlaceholder, ",") + ` ON CONFLICT (REPO_ID, USER_ID, ISSUE_NUMBER) DO UPDATE SET ISSUE_DATA = EXCLUDED.ISSUE_DATA;`
-	_, err := database.DB.Exec(sqlQuery, values...)
+	_, err := db.Exec(sqlQuery, values...)
 	if err != nil {
 		return fmt.Errorf("[CreateBulkNotificationsByRepoID]: %v", err)
 	}
@@ -106,11 +107,13 @@ func CreateBulkNotificationsByRepoID(repoID uuid.UUID, issueDataPerUserMap map[u
 	return nil
 }
 
-// UpdateSentNotificationData updates the status of `sent` to `true` for all notification data for the given userID and repoID
-func UpdateSentNotificationData(userID, repoID string) error {
-	sqlQuery := `UPDATE NOTIFICATION_DATA SET SENT = 'T' WHERE USER_ID = $1 AND REPO_ID = $2`
+// UpdateSentNotificationData updates the status of `sent` to `true` for the notification data of the given issueNumbers
+// for the given userID and repoID. Only the issues which were emailed must be given, as notification data may have been
+// created for other issues since the email was built.
+func UpdateSentNotificationData(userID, repoID string, issueNumbers []float64) error {
+	sqlQuery := `UPDATE NOTIFICATION_DATA SET SENT = 'T' WHERE USER_ID = $1 AND REPO_ID = $2 AND ISSUE_NUMBER = ANY($3)`
 
-	_, err := database.DB.Exec(sqlQuery, userID, repoID)
+	_, err := database.DB.Exec(sqlQuery, userID, repoID, pq.Array(issueNumbers))
 	if err != nil {
 		return fmt.Errorf("[UpdateSentNotificationData]: %v", err)
 	}
@@ -118,6 +121,24 @@ func UpdateSentNotificationData(userID, repoID string) error {
 	return nil
 }
 
+// RefreshPendingNotifications updates the issue data of the given notification data for the given repoID, as long as
+// they are not sent yet. Notification data which got deleted in the meantime, e.g. as the issue got retracted, are
+// not created again.
+func RefreshPendingNotifications(db database.Executor, repoID uuid.UUID, issueDataPerUserMap map[uuid.UUID]map[float64]Issue) error {
+	sqlQuery := `UPDATE NOTIFICATION_DATA SET ISSUE_DATA = $4 WHERE USER_ID = $1 AND REPO_ID = $2 AND ISSUE_NUMBER = $3 AND SENT = 'F'`
+
+	for userID, issues := range issueDataPerUserMap {
+		for issueNumber, issueData := range issues {
+			_, err := db.Exec(sqlQuery, userID, repoID, issueNumber, issueData)
+			if err != nil {
+				return fmt.Errorf("[RefreshPendingNotifications]: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // DeleteAllSentNotificationData deletes all notification data where `sent` status = `true`
 func DeleteAllSentNotificationData() error {
 	sqlQuery := `DELETE FROM NOTIFICATION_DATA WHERE SENT = 'T'`
@@ -129,3 +150,66 @@ func DeleteAllSentNotificationData() error {
 
 	return nil
 }
+
+// DeletePendingNotificationsByIssue deletes the notification data not sent yet for the given issue of the given repoID for all users
+func DeletePendingNotificationsByIssue(db database.Executor, repoID uuid.UUID, issueNumber float64) error {
+	sqlQuery := `DELETE FROM NOTIFICATION_DATA WHERE SENT = 'F' AND REPO_ID = $1 AND ISSUE_NUMBER = $2`
+
+	_, err := db.Exec(sqlQuery, repoID, issueNumber)
+	if err != nil {
+		return fmt.Errorf("[DeletePendingNotificationsByIssue]: %v", err)
+	}
+
+	return nil
+}
+
+// DeletePendingNotificationsByIssueAndUsers deletes the notification data not sent yet for the given issue of the given repoID for the given users
+func DeletePendingNotificationsByIssueAndUsers(db database.Executor, repoID uuid.UUID, issueNumber float64, userIDs []uuid.UUID) error {
+	sqlQuery := `DELETE FROM NOTIFICATION_DATA WHERE SENT = 'F' AND REPO_ID = $1 AND ISSUE_NUMBER = $2 AND USER_ID = ANY($3)`
+
+	ids := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		ids = append(ids, userID.String())
+	}
+
+	_, err := db.Exec(sqlQuery, repoID, issueNumber, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("[DeletePendingNotificationsByIssueAndUsers]: %v", err)
+	}
+
+	return nil
+}
+
+// PendingNotification struct defines a single notification data not sent yet
+type PendingNotification struct {
+	UserID   uuid.UUID `json:"userID" db:"user_id"`
+	RepoID   uuid.UUID `json:"repoID" db:"repo_id"`
+	RepoName string    `json:"repoName" db:"repo_name"`
+	Issue    Issue     `json:"issueData" db:"issue_data"`
+}
+
+// GetAllPendingNotificationData returns all notification data not sent yet for all users
+func GetAllPendingNotificationData() ([]PendingNotification, error) {
+	sqlQuery := `SELECT ND.USER_ID, ND.REPO_ID, GR.REPO_NAME, ND.ISSUE_DATA
+		FROM NOTIFICATION_DATA ND
+		INNER JOIN GLOBAL_REPOSITORY GR ON GR.REPO_ID = ND.REPO_ID
+		WHERE ND.SENT = 'F'`
+
+	rows, err := database.DB.Query(sqlQuery)
+	if err != nil {
+		return nil, fmt.Errorf("[GetAllPendingNotificationData]: %v", err)
+	}
+	defer rows.Close()
+
+	var data []PendingNotification
+	for rows.Next() {
+		var pn PendingNotification
+		if err := rows.Scan(&pn.UserID, &pn.RepoID, &pn.RepoName, &pn.Issue); err != nil {
+			return nil, fmt.Errorf("[GetAllPendingNotificationData]: %v", err)
+		}
+
+		data = append(data, pn)
+	}
+
+	return data, nil
+}
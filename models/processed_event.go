@@ -0,0 +1,67 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+	"github.com/lib/pq"
+)
+
+// GetProcessedEventIDs returns which of the given eventIDs of the given source were already processed
+func GetProcessedEventIDs(source string, eventIDs []int64) (map[int64]bool, error) {
+	processed := make(map[int64]bool)
+	if len(eventIDs) == 0 {
+		return processed, nil
+	}
+
+	sqlQuery := `SELECT EVENT_ID FROM PROCESSED_EVENT WHERE SOURCE = $1 AND EVENT_ID = ANY($2)`
+
+	rows, err := database.DB.Query(sqlQuery, source, pq.Array(eventIDs))
+	if err != nil {
+		return nil, fmt.Errorf("[GetProcessedEventIDs]: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventID int64
+		err = rows.Scan(&eventID)
+		if err != nil {
+			return nil, fmt.Errorf("[GetProcessedEventIDs]: %v", err)
+		}
+		processed[eventID] = true
+	}
+
+	return processed, rows.Err()
+}
+
+// MarkEventsProcessed records the given eventIDs of the given source as processed for the given repoID
+func MarkEventsProcessed(db database.Executor, source string, repoID uuid.UUID, eventIDs []int64) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+
+	sqlQuery := `INSERT INTO PROCESSED_EVENT (SOURCE, EVENT_ID, REPO_ID, PROCESSED_AT) SELECT $1, UNNEST($2::BIGINT[]), $3, NOW()
+		ON CONFLICT (SOURCE, EVENT_ID) DO NOTHING`
+
+	_, err := db.Exec(sqlQuery, source, pq.Array(eventIDs), repoID)
+	if err != nil {
+		return fmt.Errorf("[MarkEventsProcessed]: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredProcessedEvents deletes the processed events older than the given ttl
+func DeleteExpiredProcessedEvents(ttl time.Duration) (int64, error) {
+	sqlQuery := `DELETE FROM PROCESSED_EVENT WHERE PROCESSED_AT < $1`
+
+	res, err := database.DB.Exec(sqlQuery, time.Now().Add(-ttl))
+	if err != nil {
+		return 0, fmt.Errorf("[DeleteExpiredProcessedEvents]: %v", err)
+	}
+
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+	"github.com/lib/pq"
+)
+
+// Statuses of the queued jobs
+const (
+	JobQueued  string = "queued"
+	JobRunning string = "running"
+	JobDone    string = "done"
+	JobFailed  string = "failed"
+)
+
+// QueuedJob struct stores a unit of work claimed by the workers of any replica, such as fetching the
+// issue events of a repository or delivering the digest of a user
+type QueuedJob struct {
+	ID          int64           `json:"id" db:"id"`
+	Kind        string          `json:"kind" db:"kind"`
+	TargetID    uuid.UUID       `json:"targetID" db:"target_id"`
+	Payload     json.RawMessage `json:"payload" db:"payload"`
+	Status      string          `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"maxAttempts" db:"max_attempts"`
+	LastError   string          `json:"lastError" db:"last_error"`
+}
+
+// EnqueueJob queues a job of the given kind for the given targetID and returns its ID. If a job of
+// the same kind is already queued or running for the target, its ID is returned instead.
+func EnqueueJob(kind string, targetID uuid.UUID, payload interface{}, maxAttempts int) (int64, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("[EnqueueJob]: %v", err)
+	}
+
+	sqlQuery := `INSERT INTO JOB_QUEUE (KIND, TARGET_ID, PAYLOAD, MAX_ATTEMPTS) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (KIND, TARGET_ID) WHERE STATUS IN ('queued', 'running') DO NOTHING
+		RETURNING ID`
+
+	var id int64
+	err = database.DB.QueryRow(sqlQuery, kind, targetID, payloadBytes, maxAttempts).Scan(&id)
+	if err == sql.ErrNoRows {
+		sqlQuery = `SELECT ID FROM JOB_QUEUE WHERE KIND = $1 AND TARGET_ID = $2 AND STATUS IN ('queued', 'running')`
+		err = database.DB.QueryRow(sqlQuery, kind, targetID).Scan(&id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("[EnqueueJob]: %v", err)
+	}
+
+	return id, nil
+}
+
+// ClaimJobs claims at most `limit` jobs which are due, including the running jobs whose visibility
+// timeout expired as their worker most likely died. Claimed jobs are hidden from the other workers
+// for the given visibility timeout. Rows locked by another worker are skipped rather than waited for.
+func ClaimJobs(limit int, visibilityTimeout time.Duration) ([]QueuedJob, error) {
+	sqlQuery := `UPDATE JOB_QUEUE SET STATUS = 'running', ATTEMPTS = ATTEMPTS + 1, RUN_AT = NOW() + make_interval(secs => $2), UPDATED_AT = NOW()
+		WHERE ID IN (
+			SELECT ID FROM JOB_QUEUE
+			WHERE STATUS IN ('queued', 'running') AND RUN_AT <= NOW() AND ATTEMPTS < MAX_ATTEMPTS
+			ORDER BY RUN_AT
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ID, KIND, TARGET_ID, PAYLOAD, STATUS, ATTEMPTS, MAX_ATTEMPTS, LAST_ERROR`
+
+	rows, err := database.DB.Query(sqlQuery, limit, visibilityTimeout.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("[ClaimJobs]: %v", err)
+	}
+	defer rows.Close()
+
+	var data []QueuedJob
+	for rows.Next() {
+		var job QueuedJob
+		err := rows.Scan(&job.ID, &job.Kind, &job.TargetID, &job.Payload, &job.Status, &job.Attempts, &job.MaxAttempts, &job.LastError)
+		if err != nil {
+			return nil, fmt.Errorf("[ClaimJobs]: %v", err)
+		}
+		data = append(data, job)
+	}
+
+	return data, rows.Err()
+}
+
+// CompleteJob marks the job as done. Jobs claimed again by another worker in the meantime, as their
+// visibility timeout expired, are left to that worker.
+func CompleteJob(job QueuedJob) error {
+	sqlQuery := `UPDATE JOB_QUEUE SET STATUS = 'done', LAST_ERROR = '', UPDATED_AT = NOW() WHERE ID = $1 AND ATTEMPTS = $2`
+
+	_, err := database.DB.Exec(sqlQuery, job.ID, job.Attempts)
+	if err != nil {
+		return fmt.Errorf("[CompleteJob]: %v", err)
+	}
+
+	return nil
+}
+
+// FailJob records the error of the last attempt of the job and queues it again to be retried at the
+// given time, or marks it as failed if it has no attempts left or retryAt is zero. As for CompleteJob,
+// jobs claimed again by another worker are left untouched.
+func FailJob(job QueuedJob, jobErr error, retryAt time.Time) error {
+	status := JobQueued
+	if job.Attempts >= job.MaxAttempts || retryAt.IsZero() {
+		status = JobFailed
+		retryAt = time.Now()
+	}
+
+	sqlQuery := `UPDATE JOB_QUEUE SET STATUS = $3, LAST_ERROR = $4, RUN_AT = $5, UPDATED_AT = NOW() WHERE ID = $1 AND ATTEMPTS = $2`
+
+	_, err := database.DB.Exec(sqlQuery, job.ID, job.Attempts, status, jobErr.Error(), retryAt)
+	if err != nil {
+		return fmt.Errorf("[FailJob]: %v", err)
+	}
+
+	return nil
+}
+
+// FailAbandonedJobs marks as failed the running jobs whose visibility timeout expired and which have
+// no attempts left, as no worker will claim them anymore
+func FailAbandonedJobs() error {
+	sqlQuery := `UPDATE JOB_QUEUE SET STATUS = 'failed', LAST_ERROR = 'visibility timeout expired', UPDATED_AT = NOW()
+		WHERE STATUS = 'running' AND RUN_AT <= NOW() AND ATTEMPTS >= MAX_ATTEMPTS`
+
+	_, err := database.DB.Exec(sqlQuery)
+	if err != nil {
+		return fmt.Errorf("[FailAbandonedJobs]: %v", err)
+	}
+
+	return nil
+}
+
+// GetJobs returns the jobs of the given ids by their ID
+func GetJobs(ids []int64) (map[int64]QueuedJob, error) {
+	sqlQuery := `SELECT ID, KIND, TARGET_ID, PAYLOAD, STATUS, ATTEMPTS, MAX_ATTEMPTS, LAST_ERROR FROM JOB_QUEUE WHERE ID = ANY($1)`
+
+	rows, err := database.DB.Query(sqlQuery, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("[GetJobs]: %v", err)
+	}
+	defer rows.Close()
+
+	data := make(map[int64]QueuedJob, len(ids))
+	for rows.Next() {
+		var job QueuedJob
+		err := rows.Scan(&job.ID, &job.Kind, &job.TargetID, &job.Payload, &job.Status, &job.Attempts, &job.MaxAttempts, &job.LastError)
+		if err != nil {
+			return nil, fmt.Errorf("[GetJobs]: %v", err)
+		}
+		data[job.ID] = job
+	}
+
+	return data, rows.Err()
+}
+
+// DeleteFinishedJobs deletes the done and failed jobs last updated before the given ttl
+func DeleteFinishedJobs(ttl time.Duration) (int64, error) {
+	sqlQuery := `DELETE FROM JOB_QUEUE WHERE STATUS IN ('done', 'failed') AND UPDATED_AT < $1`
+
+	res, err := database.DB.Exec(sqlQuery, time.Now().Add(-ttl))
+	if err != nil {
+		return 0, fmt.Errorf("[DeleteFinishedJobs]: %v", err)
+	}
+
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+)
+
+// RepositoryCursor struct stores the most recent processed issue event of a repository
+type RepositoryCursor struct {
+	RepoID      uuid.UUID `json:"repoID" db:"repo_id"`
+	LastEventID int64     `json:"lastEventID" db:"last_event_id"`
+	LastEventAt time.Time `json:"lastEventAt" db:"last_event_at"`
+}
+
+// GetRepositoryCursor returns the cursor of the given repoID and whether the repository was ever fetched
+func GetRepositoryCursor(repoID uuid.UUID) (RepositoryCursor, bool, error) {
+	sqlQuery := `SELECT LAST_EVENT_ID, LAST_EVENT_AT FROM REPOSITORY_CURSOR WHERE REPO_ID = $1`
+
+	data := RepositoryCursor{RepoID: repoID}
+	err := database.DB.QueryRow(sqlQuery, repoID).Scan(&data.LastEventID, &data.LastEventAt)
+	if err == sql.ErrNoRows {
+		return data, false, nil
+	}
+	if err != nil {
+		return data, false, fmt.Errorf("[GetRepositoryCursor]: %v", err)
+	}
+
+	return data, true, nil
+}
+
+// UpsertRepositoryCursor saves the most recent processed issue event for the given repoID
+func UpsertRepositoryCursor(repoID uuid.UUID, lastEventID int64, lastEventAt time.Time) error {
+	sqlQuery := `INSERT INTO REPOSITORY_CURSOR (REPO_ID, LAST_EVENT_ID, LAST_EVENT_AT, UPDATED_AT) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (REPO_ID) DO UPDATE SET LAST_EVENT_ID = EXCLUDED.LAST_EVENT_ID, LAST_EVENT_AT = EXCLUDED.LAST_EVENT_AT, UPDATED_AT = EXCLUDED.UPDATED_AT`
+
+	_, err := database.DB.Exec(sqlQuery, repoID, lastEventID, lastEventAt)
+	if err != nil {
+		return fmt.Errorf("[UpsertRepositoryCursor]: %v", err)
+	}
+
+	return nil
+}
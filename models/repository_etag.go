@@ -0,0 +1,43 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+)
+
+// RepositoryETag struct stores the validators of the last issue events response for a repository
+type RepositoryETag struct {
+	RepoID       uuid.UUID `json:"repoID" db:"repo_id"`
+	ETag         string    `json:"etag" db:"etag"`
+	LastModified string    `json:"lastModified" db:"last_modified"`
+}
+
+// GetRepositoryETag returns the cached validators for the given repoID. An empty RepositoryETag
+// is returned if nothing has been cached yet.
+func GetRepositoryETag(repoID uuid.UUID) (RepositoryETag, error) {
+	sqlQuery := `SELECT ETAG, LAST_MODIFIED FROM REPOSITORY_ETAG WHERE REPO_ID = $1`
+
+	data := RepositoryETag{RepoID: repoID}
+	err := database.DB.QueryRow(sqlQuery, repoID).Scan(&data.ETag, &data.LastModified)
+	if err != nil && err != sql.ErrNoRows {
+		return data, fmt.Errorf("[GetRepositoryETag]: %v", err)
+	}
+
+	return data, nil
+}
+
+// UpsertRepositoryETag saves the validators of the latest issue events response for the given repoID
+func UpsertRepositoryETag(repoID uuid.UUID, etag, lastModified string) error {
+	sqlQuery := `INSERT INTO REPOSITORY_ETAG (REPO_ID, ETAG, LAST_MODIFIED, UPDATED_AT) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (REPO_ID) DO UPDATE SET ETAG = EXCLUDED.ETAG, LAST_MODIFIED = EXCLUDED.LAST_MODIFIED, UPDATED_AT = EXCLUDED.UPDATED_AT`
+
+	_, err := database.DB.Exec(sqlQuery, repoID, etag, lastModified)
+	if err != nil {
+		return fmt.Errorf("[UpsertRepositoryETag]: %v", err)
+	}
+
+	return nil
+}
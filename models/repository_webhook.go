@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+)
+
+// RepositoryWebhook struct stores since when the issue events of a repository are delivered by GitHub webhooks
+type RepositoryWebhook struct {
+	RepoID         uuid.UUID `json:"repoID" db:"repo_id"`
+	CoveredSince   time.Time `json:"coveredSince" db:"covered_since"`
+	LastDeliveryAt time.Time `json:"lastDeliveryAt" db:"last_delivery_at"`
+}
+
+// GetRepositoryWebhook returns the webhook deliveries of the given repoID and whether it ever received one
+func GetRepositoryWebhook(repoID uuid.UUID) (RepositoryWebhook, bool, error) {
+	sqlQuery := `SELECT COVERED_SINCE, LAST_DELIVERY_AT FROM REPOSITORY_WEBHOOK WHERE REPO_ID = $1`
+
+	data := RepositoryWebhook{RepoID: repoID}
+	err := database.DB.QueryRow(sqlQuery, repoID).Scan(&data.CoveredSince, &data.LastDeliveryAt)
+	if err == sql.ErrNoRows {
+		return data, false, nil
+	}
+	if err != nil {
+		return data, false, fmt.Errorf("[GetRepositoryWebhook]: %v", err)
+	}
+
+	return data, true, nil
+}
+
+// UpsertRepositoryWebhook records a webhook delivery for the given repoID. The coverage starts over from the delivery
+// if the previous one is older than lapsedBefore.
+func UpsertRepositoryWebhook(repoID uuid.UUID, deliveredAt, lapsedBefore time.Time) error {
+	sqlQuery := `INSERT INTO REPOSITORY_WEBHOOK (REPO_ID, COVERED_SINCE, LAST_DELIVERY_AT) VALUES ($1, $2, $2)
+		ON CONFLICT (REPO_ID) DO UPDATE SET
+			COVERED_SINCE = CASE WHEN REPOSITORY_WEBHOOK.LAST_DELIVERY_AT < $3 THEN EXCLUDED.COVERED_SINCE ELSE REPOSITORY_WEBHOOK.COVERED_SINCE END,
+			LAST_DELIVERY_AT = GREATEST(REPOSITORY_WEBHOOK.LAST_DELIVERY_AT, EXCLUDED.LAST_DELIVERY_AT)`
+
+	_, err := database.DB.Exec(sqlQuery, repoID, deliveredAt, lapsedBefore)
+	if err != nil {
+		return fmt.Errorf("[UpsertRepositoryWebhook]: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteRepositoryWebhook ends the webhook coverage of the given repoID, so that its issue events are polled again
+func DeleteRepositoryWebhook(repoID uuid.UUID) error {
+	sqlQuery := `DELETE FROM REPOSITORY_WEBHOOK WHERE REPO_ID = $1`
+
+	_, err := database.DB.Exec(sqlQuery, repoID)
+	if err != nil {
+		return fmt.Errorf("[DeleteRepositoryWebhook]: %v", err)
+	}
+
+	return nil
+}
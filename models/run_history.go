@@ -0,0 +1,176 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+)
+
+// Statuses of the repositories and users processed by a run
+const (
+	RunPending   string = "pending"
+	RunSucceeded string = "succeeded"
+	RunFailed    string = "failed"
+	RunTimedOut  string = "timed_out"
+)
+
+// RunRepository struct stores the outcome of fetching a repository during a run
+type RunRepository struct {
+	RunID                int64     `json:"runID" db:"run_id"`
+	RepoID               uuid.UUID `json:"repoID" db:"repo_id"`
+	RepoName             string    `json:"repoName" db:"repo_name"`
+	Status               string    `json:"status" db:"status"`
+	EventsScanned        int       `json:"eventsScanned" db:"events_scanned"`
+	IssuesMatched        int       `json:"issuesMatched" db:"issues_matched"`
+	NotificationsCreated int       `json:"notificationsCreated" db:"notifications_created"`
+	Error                string    `json:"error" db:"error"`
+}
+
+// RunUser struct stores the outcome of sending the digest of a user during a run
+type RunUser struct {
+	RunID    int64     `json:"runID" db:"run_id"`
+	UserID   uuid.UUID `json:"userID" db:"user_id"`
+	Username string    `json:"username" db:"username"`
+	Status   string    `json:"status" db:"status"`
+	Issues   int       `json:"issues" db:"issues"`
+	Error    string    `json:"error" db:"error"`
+}
+
+// CreateRun records the start of a run of the given kind and returns its ID
+func CreateRun(kind string) (int64, error) {
+	sqlQuery := `INSERT INTO RUN_HISTORY (KIND, STARTED_AT) VALUES ($1, NOW()) RETURNING ID`
+
+	var id int64
+	err := database.DB.QueryRow(sqlQuery, kind).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("[CreateRun]: %v", err)
+	}
+
+	return id, nil
+}
+
+// AddRunError appends an error which is not specific to a repository or a user to the run
+func AddRunError(runID int64, runErr error) error {
+	sqlQuery := `UPDATE RUN_HISTORY SET ERRORS = array_append(ERRORS, $2) WHERE ID = $1`
+
+	_, err := database.DB.Exec(sqlQuery, runID, runErr.Error())
+	if err != nil {
+		return fmt.Errorf("[AddRunError]: %v", err)
+	}
+
+	return nil
+}
+
+// runTotalsQuery records the totals of the run from the outcomes of its repositories and users
+const runTotalsQuery = `UPDATE RUN_HISTORY SET
+	REPOSITORIES_FETCHED = (SELECT COUNT(*) FROM RUN_REPOSITORY WHERE RUN_ID = $1 AND STATUS = 'succeeded'),
+	EVENTS_SCANNED = (SELECT COALESCE(SUM(EVENTS_SCANNED), 0) FROM RUN_REPOSITORY WHERE RUN_ID = $1),
+	ISSUES_MATCHED = (SELECT COALESCE(SUM(ISSUES_MATCHED), 0) FROM RUN_REPOSITORY WHERE RUN_ID = $1),
+	NOTIFICATIONS_CREATED = (SELECT COALESCE(SUM(NOTIFICATIONS_CREATED), 0) FROM RUN_REPOSITORY WHERE RUN_ID = $1),
+	EMAILS_SENT = (SELECT COUNT(*) FROM RUN_USER WHERE RUN_ID = $1 AND STATUS = 'succeeded'),
+	EMAILS_FAILED = (SELECT COUNT(*) FROM RUN_USER WHERE RUN_ID = $1 AND STATUS <> 'succeeded')
+	WHERE ID = $1`
+
+// FinishRun marks the repositories and users of the run which are still pending as timed out and
+// records the end of the run along with its totals
+func FinishRun(runID int64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("[FinishRun]: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, sqlQuery := range []string{
+		`SELECT ID FROM RUN_HISTORY WHERE ID = $1 FOR UPDATE`,
+		`UPDATE RUN_REPOSITORY SET STATUS = 'timed_out', UPDATED_AT = NOW() WHERE RUN_ID = $1 AND STATUS = 'pending'`,
+		`UPDATE RUN_USER SET STATUS = 'timed_out', UPDATED_AT = NOW() WHERE RUN_ID = $1 AND STATUS = 'pending'`,
+		`UPDATE RUN_HISTORY SET FINISHED_AT = NOW() WHERE ID = $1`,
+		runTotalsQuery,
+	} {
+		_, err = tx.Exec(sqlQuery, runID)
+		if err != nil {
+			return fmt.Errorf("[FinishRun]: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[FinishRun]: %v", err)
+	}
+
+	return nil
+}
+
+// upsertRunOutcome saves the outcome of a repository or user of the run. An outcome saved after the
+// run finished, e.g. by a job still queued when its stage timed out, updates the totals of the run.
+// The run is locked so that concurrent outcomes do not compute the totals without each other.
+func upsertRunOutcome(runID int64, sqlQuery string, args ...interface{}) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SELECT ID FROM RUN_HISTORY WHERE ID = $1 FOR UPDATE`, runID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(sqlQuery, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(runTotalsQuery+` AND FINISHED_AT IS NOT NULL`, runID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpsertRunRepository saves the outcome of fetching a repository during a run
+func UpsertRunRepository(r RunRepository) error {
+	sqlQuery := `INSERT INTO RUN_REPOSITORY (RUN_ID, REPO_ID, REPO_NAME, STATUS, EVENTS_SCANNED, ISSUES_MATCHED, NOTIFICATIONS_CREATED, ERROR, UPDATED_AT)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		ON CONFLICT (RUN_ID, REPO_ID) DO UPDATE SET STATUS = EXCLUDED.STATUS, EVENTS_SCANNED = EXCLUDED.EVENTS_SCANNED,
+			ISSUES_MATCHED = EXCLUDED.ISSUES_MATCHED, NOTIFICATIONS_CREATED = EXCLUDED.NOTIFICATIONS_CREATED,
+			ERROR = EXCLUDED.ERROR, UPDATED_AT = EXCLUDED.UPDATED_AT`
+
+	err := upsertRunOutcome(r.RunID, sqlQuery, r.RunID, r.RepoID, r.RepoName, r.Status, r.EventsScanned, r.IssuesMatched, r.NotificationsCreated, r.Error)
+	if err != nil {
+		return fmt.Errorf("[UpsertRunRepository]: %v", err)
+	}
+
+	return nil
+}
+
+// UpsertRunUser saves the outcome of sending the digest of a user during a run
+func UpsertRunUser(u RunUser) error {
+	sqlQuery := `INSERT INTO RUN_USER (RUN_ID, USER_ID, USERNAME, STATUS, ISSUES, ERROR, UPDATED_AT)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (RUN_ID, USER_ID) DO UPDATE SET STATUS = EXCLUDED.STATUS, ISSUES = EXCLUDED.ISSUES,
+			ERROR = EXCLUDED.ERROR, UPDATED_AT = EXCLUDED.UPDATED_AT`
+
+	err := upsertRunOutcome(u.RunID, sqlQuery, u.RunID, u.UserID, u.Username, u.Status, u.Issues, u.Error)
+	if err != nil {
+		return fmt.Errorf("[UpsertRunUser]: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredRuns deletes the runs started before the given ttl along with their repositories and users
+func DeleteExpiredRuns(ttl time.Duration) (int64, error) {
+	sqlQuery := `DELETE FROM RUN_HISTORY WHERE STARTED_AT < $1`
+
+	res, err := database.DB.Exec(sqlQuery, time.Now().Add(-ttl))
+	if err != nil {
+		return 0, fmt.Errorf("[DeleteExpiredRuns]: %v", err)
+	}
+
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
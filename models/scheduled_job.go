@@ -0,0 +1,38 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/issue-notifier/notification-service/database"
+)
+
+// GetScheduledJobLastRunAt returns when the job of the given name last ran and whether it ever ran
+func GetScheduledJobLastRunAt(name string) (time.Time, bool, error) {
+	sqlQuery := `SELECT LAST_RUN_AT FROM SCHEDULED_JOB WHERE NAME = $1`
+
+	var lastRunAt time.Time
+	err := database.DB.QueryRow(sqlQuery, name).Scan(&lastRunAt)
+	if err == sql.ErrNoRows {
+		return lastRunAt, false, nil
+	}
+	if err != nil {
+		return lastRunAt, false, fmt.Errorf("[GetScheduledJobLastRunAt]: %v", err)
+	}
+
+	return lastRunAt, true, nil
+}
+
+// UpsertScheduledJobLastRunAt saves when the job of the given name last ran
+func UpsertScheduledJobLastRunAt(name string, lastRunAt time.Time) error {
+	sqlQuery := `INSERT INTO SCHEDULED_JOB (NAME, LAST_RUN_AT, UPDATED_AT) VALUES ($1, $2, NOW())
+		ON CONFLICT (NAME) DO UPDATE SET LAST_RUN_AT = EXCLUDED.LAST_RUN_AT, UPDATED_AT = EXCLUDED.UPDATED_AT`
+
+	_, err := database.DB.Exec(sqlQuery, name, lastRunAt)
+	if err != nil {
+		return fmt.Errorf("[UpsertScheduledJobLastRunAt]: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// inFlight tracks the tasks which are running, so that they are given a chance to finish on shutdown
+var inFlight sync.WaitGroup
+
+// stageTask is a unit of work of a stage, such as fetching a repository or sending an email to a user
+type stageTask struct {
+	// key identifies the task in the run summary
+	key string
+	run func(ctx context.Context) error
+}
+
+// stageSummary is the outcome of the tasks of a stage
+type stageSummary struct {
+	name      string
+	succeeded []string
+	failed    map[string]error
+	// timedOut are the tasks which did not finish, or did not even start, before the deadline
+	timedOut []string
+	elapsed  time.Duration
+}
+
+type stageResult struct {
+	key string
+	err error
+}
+
+// runStage runs the tasks with at most `concurrency` of them at a time and waits for all of them to
+// finish. Once the deadline is exceeded, or the given context is cancelled, the context of the running
+// tasks is cancelled, the remaining tasks are not started and the stage returns without waiting any
+// longer, so that a slow repository or user cannot hold up the next stages. No deadline is enforced
+// if it is 0.
+func runStage(ctx context.Context, name string, concurrency int, deadline time.Duration, tasks []stageTask) stageSummary {
+	summary := stageSummary{name: name, failed: make(map[string]error)}
+	startedAt := time.Now()
+
+	var cancel context.CancelFunc
+	if deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	// Buffered so that the tasks finishing after the deadline do not block forever
+	results := make(chan stageResult, len(tasks))
+	go func() {
+		sem := make(chan struct{}, concurrency)
+		for _, t := range tasks {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			// Both cases may be ready at once
+			if ctx.Err() != nil {
+				return
+			}
+
+			inFlight.Add(1)
+			go func(t stageTask) {
+				defer inFlight.Done()
+				defer func() { <-sem }()
+				results <- stageResult{key: t.key, err: runTask(ctx, t)}
+			}(t)
+		}
+	}()
+
+	finished := make(map[string]bool, len(tasks))
+wait:
+	for len(finished) < len(tasks) {
+		select {
+		case r := <-results:
+			finished[r.key] = true
+			if r.err != nil {
+				summary.failed[r.key] = r.err
+			} else {
+				summary.succeeded = append(summary.succeeded, r.key)
+			}
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	for _, t := range tasks {
+		if !finished[t.key] {
+			summary.timedOut = append(summary.timedOut, t.key)
+		}
+	}
+	summary.elapsed = time.Since(startedAt)
+
+	return summary
+}
+
+// runTask runs the task, turning a panic into an error so that it only fails the task
+func runTask(ctx context.Context, t stageTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return t.run(ctx)
+}
+
+// log logs the outcome of the stage and of each task which did not succeed
+func (s stageSummary) log() {
+	utils.LogInfo.Println("Stage:", s.name, "finished in:", s.elapsed, "with", len(s.succeeded), "succeeded,", len(s.failed), "failed and", len(s.timedOut), "timed out")
+	for key, err := range s.failed {
+		utils.LogError.Println("Stage:", s.name, "failed for:", key, ". Error:", err)
+	}
+	for _, key := range s.timedOut {
+		utils.LogError.Println("Stage:", s.name, "timed out for:", key)
+	}
+}
+
+// waitForInFlight waits for the running tasks to finish for at most the given grace period and
+// reports whether they did
+func waitForInFlight(gracePeriod time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(gracePeriod):
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunStage(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		tasks       int
+		// fail are the tasks which fail, by index, and panic the ones which panic
+		fail           map[int]bool
+		panic          map[int]bool
+		wantMaxRunning int
+	}{
+		{name: "tasks run at most concurrency at a time", concurrency: 2, tasks: 6, wantMaxRunning: 2},
+		{name: "concurrency below 1 runs one task at a time", concurrency: 0, tasks: 3, wantMaxRunning: 1},
+		{name: "failed and panicking tasks are reported", concurrency: 3, tasks: 4, fail: map[int]bool{1: true}, panic: map[int]bool{2: true}, wantMaxRunning: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+
+			var tasks []stageTask
+			for i := 0; i < tt.tasks; i++ {
+				i := i
+				tasks = append(tasks, stageTask{key: strconv.Itoa(i), run: func(ctx context.Context) error {
+					mu.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					mu.Unlock()
+
+					time.Sleep(20 * time.Millisecond)
+
+					mu.Lock()
+					running--
+					mu.Unlock()
+
+					if tt.panic[i] {
+						panic("unexpected payload")
+					}
+					if tt.fail[i] {
+						return errors.New("Received 502 Bad Gateway from GitHub")
+					}
+					return nil
+				}})
+			}
+
+			summary := runStage(context.Background(), "test", tt.concurrency, time.Minute, tasks)
+
+			if maxRunning != tt.wantMaxRunning {
+				t.Errorf("max running tasks = %d, want %d", maxRunning, tt.wantMaxRunning)
+			}
+			if wantFailed := len(tt.fail) + len(tt.panic); len(summary.failed) != wantFailed {
+				t.Errorf("failed = %v, want %d failed tasks", summary.failed, wantFailed)
+			}
+			if wantSucceeded := tt.tasks - len(tt.fail) - len(tt.panic); len(summary.succeeded) != wantSucceeded {
+				t.Errorf("succeeded = %v, want %d succeeded tasks", summary.succeeded, wantSucceeded)
+			}
+			if len(summary.timedOut) != 0 {
+				t.Errorf("timedOut = %v, want none", summary.timedOut)
+			}
+		})
+	}
+}
+
+func TestRunStageStopsEarly(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		// cancelAfter cancels the context of the stage after the given time, if not 0
+		cancelAfter time.Duration
+	}{
+		{name: "deadline exceeded", deadline: 50 * time.Millisecond},
+		{name: "context cancelled", cancelAfter: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			// The tasks only return once the stage returned, so they can only be reported as timed out
+			release := make(chan struct{})
+			defer close(release)
+			cancelled := make(chan string, 3)
+			started := make(chan string, 3)
+
+			var tasks []stageTask
+			for i := 0; i < 3; i++ {
+				key := strconv.Itoa(i)
+				tasks = append(tasks, stageTask{key: key, run: func(ctx context.Context) error {
+					started <- key
+					<-ctx.Done()
+					cancelled <- key
+					<-release
+					return ctx.Err()
+				}})
+			}
+
+			startedAt := time.Now()
+			summary := runStage(ctx, "test", 1, tt.deadline, tasks)
+
+			if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+				t.Fatalf("runStage() returned after %v, want it to stop at the deadline or cancellation", elapsed)
+			}
+			if len(summary.timedOut) != 3 || len(summary.succeeded) != 0 || len(summary.failed) != 0 {
+				t.Errorf("summary = %+v, want the 3 tasks timed out", summary)
+			}
+
+			select {
+			case key := <-cancelled:
+				if key != "0" {
+					t.Errorf("cancelled task = %v, want the running one", key)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("the context of the running task was not cancelled")
+			}
+			// The remaining tasks never start
+			time.Sleep(20 * time.Millisecond)
+			if len(started) != 1 {
+				t.Errorf("started tasks = %d, want only the first one", len(started))
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// Kinds of the queued jobs
+const (
+	fetchJobKind   = "fetch"
+	deliverJobKind = "deliver"
+)
+
+// queuePollInterval is how often idle workers look for due jobs, and how often a stage checks
+// whether its jobs finished
+const queuePollInterval = 5 * time.Second
+
+// queueMaxAttempts is how many times a job is attempted before it is marked as failed
+const queueMaxAttempts = 3
+
+// queuedTarget is what a job of a queued stage runs for, a repository to fetch or a user to deliver to
+type queuedTarget struct {
+	// key identifies the job in the run summary
+	key     string
+	id      uuid.UUID
+	payload interface{}
+}
+
+// queuedRepository is the payload of a fetch job
+type queuedRepository struct {
+	services.Repository
+	// RunID is the run the outcome of the job is recorded for, 0 if none
+	RunID int64 `json:"runID,omitempty"`
+}
+
+// queuedUser is the payload of a deliver job
+type queuedUser struct {
+	models.User
+	// RunID is the run the outcome of the job is recorded for, 0 if none
+	RunID int64 `json:"runID,omitempty"`
+}
+
+// runQueuedStage enqueues a job of the given kind per target, to be run by the workers of any replica,
+// and waits for all of them to finish, retries included, or for the deadline to be exceeded. Jobs
+// still pending past the deadline, or once the context is cancelled, are left in the queue and
+// reported as timed out.
+func runQueuedStage(ctx context.Context, name, kind string, deadline time.Duration, targets []queuedTarget) stageSummary {
+	summary := stageSummary{name: name, failed: make(map[string]error)}
+	startedAt := time.Now()
+
+	keys := make(map[int64]string, len(targets))
+	for _, t := range targets {
+		id, err := models.EnqueueJob(kind, t.id, t.payload, queueMaxAttempts)
+		if err != nil {
+			summary.failed[t.key] = err
+			continue
+		}
+		keys[id] = t.key
+	}
+	utils.LogInfo.Println("Enqueued", len(keys), kind, "jobs for stage:", name)
+
+wait:
+	for len(keys) > 0 && (deadline == 0 || time.Since(startedAt) < deadline) {
+		select {
+		case <-time.After(queuePollInterval):
+		case <-ctx.Done():
+			break wait
+		}
+
+		ids := make([]int64, 0, len(keys))
+		for id := range keys {
+			ids = append(ids, id)
+		}
+
+		jobs, err := models.GetJobs(ids)
+		if err != nil {
+			utils.LogError.Println("Failed to get the jobs of stage:", name, ". Error:", err)
+			continue
+		}
+
+		for id, key := range keys {
+			job, exists := jobs[id]
+			switch {
+			case !exists, job.Status == models.JobDone:
+				summary.succeeded = append(summary.succeeded, key)
+			case job.Status == models.JobFailed:
+				summary.failed[key] = errors.New(job.LastError)
+			default:
+				continue
+			}
+			delete(keys, id)
+		}
+	}
+
+	for _, key := range keys {
+		summary.timedOut = append(summary.timedOut, key)
+	}
+	summary.elapsed = time.Since(startedAt)
+
+	return summary
+}
+
+// jobQueue claims the queued jobs and records their outcome
+type jobQueue interface {
+	ClaimJobs(limit int, visibilityTimeout time.Duration) ([]models.QueuedJob, error)
+	CompleteJob(job models.QueuedJob) error
+	FailJob(job models.QueuedJob, jobErr error, retryAt time.Time) error
+	FailAbandonedJobs() error
+}
+
+// databaseJobQueue is the jobQueue shared by the replicas through the database
+type databaseJobQueue struct{}
+
+func (databaseJobQueue) ClaimJobs(limit int, visibilityTimeout time.Duration) ([]models.QueuedJob, error) {
+	return models.ClaimJobs(limit, visibilityTimeout)
+}
+
+func (databaseJobQueue) CompleteJob(job models.QueuedJob) error {
+	return models.CompleteJob(job)
+}
+
+func (databaseJobQueue) FailJob(job models.QueuedJob, jobErr error, retryAt time.Time) error {
+	return models.FailJob(job, jobErr, retryAt)
+}
+
+func (databaseJobQueue) FailAbandonedJobs() error {
+	return models.FailAbandonedJobs()
+}
+
+// jobHandler runs the work of a claimed job
+type jobHandler func(ctx context.Context, job models.QueuedJob) error
+
+// runQueueWorkers claims the due jobs of the queue and runs them with the handler, at most `concurrency`
+// at a time, until the context is cancelled. A claimed job is retried by another worker if it did not
+// finish within the visibility timeout, e.g. because the replica running it died.
+//
+// The caller must add the loop itself to inFlight, so that no job gets added once it is waited for.
+func runQueueWorkers(ctx context.Context, queue jobQueue, handle jobHandler, concurrency int, visibilityTimeout time.Duration) {
+	sem := make(chan struct{}, concurrency)
+	// Wakes up the claiming loop as soon as a worker is free
+	freed := make(chan struct{}, 1)
+
+	for {
+		// Claiming counts as an attempt, no job is claimed once shutting down
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := queue.FailAbandonedJobs()
+		if err != nil {
+			utils.LogError.Println("Failed to fail abandoned jobs. Error:", err)
+		}
+
+		if free := concurrency - len(sem); free > 0 && ctx.Err() == nil {
+			jobs, err := queue.ClaimJobs(free, visibilityTimeout)
+			if err != nil {
+				utils.LogError.Println("Failed to claim jobs. Error:", err)
+			}
+
+			for _, job := range jobs {
+				sem <- struct{}{}
+				inFlight.Add(1)
+				go func(job models.QueuedJob) {
+					defer inFlight.Done()
+					defer func() {
+						<-sem
+						select {
+						case freed <- struct{}{}:
+						default:
+						}
+					}()
+					runQueuedJob(ctx, queue, handle, job, visibilityTimeout)
+				}(job)
+			}
+		}
+
+		select {
+		case <-time.After(queuePollInterval):
+		case <-freed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runQueuedJob runs the job and records its outcome. Failed jobs are retried with an exponential
+// backoff, or once the rate limit reset if it got exhausted.
+func runQueuedJob(ctx context.Context, queue jobQueue, handle jobHandler, job models.QueuedJob, visibilityTimeout time.Duration) {
+	// The job must not outlive its claim as another worker would then run it concurrently
+	jobCtx, cancel := context.WithTimeout(ctx, visibilityTimeout)
+	defer cancel()
+
+	utils.LogInfo.Println("Running", job.Kind, "job:", job.ID, "attempt:", job.Attempts, "of", job.MaxAttempts)
+	err := runTask(jobCtx, stageTask{run: func(ctx context.Context) error {
+		return handle(ctx, job)
+	}})
+	if err == nil {
+		err = queue.CompleteJob(job)
+		if err != nil {
+			utils.LogError.Println("Failed to complete", job.Kind, "job:", job.ID, ". Error:", err)
+		}
+		return
+	}
+	utils.LogError.Println("Failed to run", job.Kind, "job:", job.ID, ". Error:", err)
+
+	retryAt := time.Now().Add(time.Duration(1<<uint(job.Attempts-1)) * time.Minute)
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		retryAt = rateLimitErr.RetryAt
+	}
+	if ctx.Err() != nil {
+		// Interrupted by a shutdown, left to the other replicas right away
+		retryAt = time.Now()
+	}
+	if errors.Is(err, errSentNotRecorded) {
+		retryAt = time.Time{}
+	}
+
+	err = queue.FailJob(job, err, retryAt)
+	if err != nil {
+		utils.LogError.Println("Failed to record the failure of", job.Kind, "job:", job.ID, ". Error:", err)
+	}
+}
+
+// handleQueuedJob runs the work of the job
+func handleQueuedJob(ctx context.Context, job models.QueuedJob) error {
+	switch job.Kind {
+	case fetchJobKind:
+		var payload queuedRepository
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("[handleQueuedJob]: %v", err)
+		}
+
+		outcome, err := processIssueEvents(ctx, payload.Repository, nil)
+		recordRepository(payload.RunID, payload.Repository, outcome, err)
+		return err
+
+	case deliverJobKind:
+		var payload queuedUser
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("[handleQueuedJob]: %v", err)
+		}
+
+		repositories, err := services.GetAllRepositories()
+		if err != nil {
+			return fmt.Errorf("[handleQueuedJob]: %v", err)
+		}
+		repositoriesByID := make(map[string]services.Repository, len(repositories))
+		for _, repository := range repositories {
+			repositoriesByID[repository.RepoID.String()] = repository
+		}
+
+		issues, err := sendEmail(payload.User, repositoriesByID)
+		recordUser(payload.RunID, payload.User, issues, err)
+		return err
+	}
+
+	return fmt.Errorf("[handleQueuedJob]: unknown job kind %v", job.Kind)
+}
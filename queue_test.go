@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLogging("production")
+	os.Exit(m.Run())
+}
+
+// jobFailure is a FailJob call of the memoryJobQueue
+type jobFailure struct {
+	err     error
+	retryAt time.Time
+}
+
+// memoryJobQueue hands out its jobs once each and records their outcome
+type memoryJobQueue struct {
+	mu        sync.Mutex
+	queued    []models.QueuedJob
+	claims    int
+	abandoned int
+	completed []int64
+	failed    map[int64]jobFailure
+}
+
+func (q *memoryJobQueue) ClaimJobs(limit int, visibilityTimeout time.Duration) ([]models.QueuedJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.claims++
+	if limit > len(q.queued) {
+		limit = len(q.queued)
+	}
+	claimed := q.queued[:limit]
+	q.queued = q.queued[limit:]
+
+	return claimed, nil
+}
+
+func (q *memoryJobQueue) CompleteJob(job models.QueuedJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.completed = append(q.completed, job.ID)
+	return nil
+}
+
+func (q *memoryJobQueue) FailJob(job models.QueuedJob, jobErr error, retryAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.failed[job.ID] = jobFailure{err: jobErr, retryAt: retryAt}
+	return nil
+}
+
+func (q *memoryJobQueue) FailAbandonedJobs() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.abandoned++
+	return nil
+}
+
+// finished returns the number of jobs whose outcome got recorded
+func (q *memoryJobQueue) finished() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.completed) + len(q.failed)
+}
+
+func TestRunQueueWorkers(t *testing.T) {
+	rateLimitReset := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name     string
+		attempts int
+		// err is returned by the handler, which waits for the job to be cancelled if it is nil and block is set
+		err   error
+		block bool
+		// wantRetryIn is the time until the retry, compared to the second, if the job is retried with a backoff
+		wantRetryIn time.Duration
+		wantRetryAt time.Time
+		wantFailed  bool
+	}{
+		{
+			name: "successful job is completed",
+		},
+		{
+			name:        "failed job is retried with a backoff",
+			attempts:    1,
+			err:         errors.New("Received 502 Bad Gateway from GitHub"),
+			wantRetryIn: time.Minute,
+			wantFailed:  true,
+		},
+		{
+			name:        "backoff doubles with each attempt",
+			attempts:    3,
+			err:         errors.New("Received 502 Bad Gateway from GitHub"),
+			wantRetryIn: 4 * time.Minute,
+			wantFailed:  true,
+		},
+		{
+			name:        "rate limited job is retried once the limit resets",
+			attempts:    1,
+			err:         fmt.Errorf("[processIssueEvents]: %w", &github.RateLimitError{RetryAt: rateLimitReset}),
+			wantRetryAt: rateLimitReset,
+			wantFailed:  true,
+		},
+		{
+			name:       "sent but unrecorded delivery is not retried",
+			attempts:   1,
+			err:        fmt.Errorf("[sendEmail]: %w", errSentNotRecorded),
+			wantFailed: true,
+		},
+		{
+			name:        "job outliving its visibility timeout is abandoned and retried",
+			attempts:    1,
+			block:       true,
+			wantRetryIn: time.Minute,
+			wantFailed:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := models.QueuedJob{ID: 1, Kind: fetchJobKind, Attempts: tt.attempts, MaxAttempts: queueMaxAttempts}
+			queue := &memoryJobQueue{queued: []models.QueuedJob{job}, failed: make(map[int64]jobFailure)}
+			handle := func(ctx context.Context, job models.QueuedJob) error {
+				if tt.block {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return tt.err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				runQueueWorkers(ctx, queue, handle, 2, 50*time.Millisecond)
+				close(done)
+			}()
+
+			for deadline := time.Now().Add(5 * time.Second); queue.finished() == 0 && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			failure, failed := queue.failed[job.ID]
+			completed := len(queue.completed) == 1
+			if failed != tt.wantFailed || completed == tt.wantFailed {
+				t.Fatalf("completed = %v, failed = %v, want failed %v", queue.completed, queue.failed, tt.wantFailed)
+			}
+			if !failed {
+				return
+			}
+
+			switch {
+			case tt.wantRetryIn != 0:
+				if d := time.Until(failure.retryAt) - tt.wantRetryIn; d > time.Second || d < -time.Second {
+					t.Errorf("retryAt = %v, want in %v", failure.retryAt, tt.wantRetryIn)
+				}
+			default:
+				if !failure.retryAt.Equal(tt.wantRetryAt) {
+					t.Errorf("retryAt = %v, want %v", failure.retryAt, tt.wantRetryAt)
+				}
+			}
+		})
+	}
+}
+
+func TestRunQueueWorkersClaimsUpToConcurrency(t *testing.T) {
+	queue := &memoryJobQueue{failed: make(map[int64]jobFailure)}
+	for id := int64(1); id <= 5; id++ {
+		queue.queued = append(queue.queued, models.QueuedJob{ID: id, Kind: fetchJobKind, Attempts: 1, MaxAttempts: queueMaxAttempts})
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	handle := func(ctx context.Context, job models.QueuedJob) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runQueueWorkers(ctx, queue, handle, 2, time.Minute)
+		close(done)
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); queue.finished() < 5 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(queue.completed) != 5 {
+		t.Errorf("completed = %v, want all 5 jobs", queue.completed)
+	}
+	if maxRunning != 2 {
+		t.Errorf("max running jobs = %d, want 2", maxRunning)
+	}
+	if queue.abandoned == 0 {
+		t.Error("FailAbandonedJobs() was not called, want the abandoned jobs to be failed before claiming")
+	}
+}
+
+func TestRunQueueWorkersStopsOnShutdown(t *testing.T) {
+	queue := &memoryJobQueue{
+		queued: []models.QueuedJob{{ID: 1, Kind: fetchJobKind, Attempts: 1, MaxAttempts: queueMaxAttempts}},
+		failed: make(map[int64]jobFailure),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runQueueWorkers(ctx, queue, func(ctx context.Context, job models.QueuedJob) error { return nil }, 2, time.Minute)
+
+	if queue.claims != 0 || queue.abandoned != 0 {
+		t.Errorf("claims = %d, abandoned = %d, want no queue access once cancelled", queue.claims, queue.abandoned)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/database"
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/sources"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// revalidationTasks returns a task per repository with pending notification data, which re-fetches
+// the current state of its issues right before the digests are built. Issues which no longer qualify
+// are dropped and the issue data snapshot of the remaining ones is refreshed.
+func revalidationTasks() ([]stageTask, error) {
+	pendingNotifications, err := models.GetAllPendingNotificationData()
+	if err != nil {
+		return nil, fmt.Errorf("[revalidationTasks]: %v", err)
+	}
+
+	pendingPerRepositoryMap := make(map[uuid.UUID][]models.PendingNotification)
+	for _, pn := range pendingNotifications {
+		pendingPerRepositoryMap[pn.RepoID] = append(pendingPerRepositoryMap[pn.RepoID], pn)
+	}
+	utils.LogInfo.Println("Revalidating", len(pendingNotifications), "pending notification data of", len(pendingPerRepositoryMap), "repositories")
+
+	repositories, err := services.GetAllRepositories()
+	if err != nil {
+		return nil, fmt.Errorf("[revalidationTasks]: %v", err)
+	}
+	repositoriesByID := make(map[uuid.UUID]services.Repository, len(repositories))
+	for _, repository := range repositories {
+		repositoriesByID[repository.RepoID] = repository
+	}
+
+	tasks := make([]stageTask, 0, len(pendingPerRepositoryMap))
+	for repoID, pending := range pendingPerRepositoryMap {
+		repository, exists := repositoriesByID[repoID]
+		if !exists {
+			utils.LogInfo.Println("Skipping revalidation of untracked repository:", pending[0].RepoName)
+			continue
+		}
+
+		pending := pending
+		tasks = append(tasks, stageTask{
+			key: repository.Host() + "/" + repository.RepoName,
+			run: func(ctx context.Context) error {
+				return revalidateRepository(ctx, repository, pending)
+			},
+		})
+	}
+
+	return tasks, nil
+}
+
+// revalidateRepository revalidates the pending notification data of a single repository
+func revalidateRepository(ctx context.Context, repository services.Repository, pending []models.PendingNotification) error {
+	currentIssues, err := fetchCurrentIssues(ctx, repository, pending)
+	if err != nil {
+		return fmt.Errorf("[revalidateRepository]: %v", err)
+	}
+
+	// The rules are evaluated as they are now, a user may have changed their subscriptions since
+	matcher, err := newIssueMatcher(repository)
+	if err != nil {
+		return fmt.Errorf("[revalidateRepository]: failed to get subscriptions: %v", err)
+	}
+
+	droppedUsersPerIssueMap := make(map[float64][]uuid.UUID)
+	issueDataPerUserMap := make(map[uuid.UUID]map[float64]models.Issue)
+	dropped, refreshed := 0, 0
+	for _, pn := range pending {
+		current, changed := currentIssues[int(pn.Issue.Number)]
+		if !changed {
+			continue
+		}
+
+		issueData, qualifies := matcher.refreshIssue(pn.UserID, pn.Issue, current)
+		if !qualifies {
+			droppedUsersPerIssueMap[pn.Issue.Number] = append(droppedUsersPerIssueMap[pn.Issue.Number], pn.UserID)
+			dropped++
+			continue
+		}
+
+		if _, exists := issueDataPerUserMap[pn.UserID]; !exists {
+			issueDataPerUserMap[pn.UserID] = make(map[float64]models.Issue)
+		}
+		issueDataPerUserMap[pn.UserID][pn.Issue.Number] = issueData
+		refreshed++
+	}
+
+	for issueNumber, userIDs := range droppedUsersPerIssueMap {
+		err := models.DeletePendingNotificationsByIssueAndUsers(database.DB, repository.RepoID, issueNumber, userIDs)
+		if err != nil {
+			return fmt.Errorf("[revalidateRepository]: failed to drop pending notification data of issue %v: %v", issueNumber, err)
+		}
+	}
+
+	if len(issueDataPerUserMap) > 0 {
+		err := models.RefreshPendingNotifications(database.DB, repository.RepoID, issueDataPerUserMap)
+		if err != nil {
+			return fmt.Errorf("[revalidateRepository]: failed to refresh pending notification data: %v", err)
+		}
+	}
+	utils.LogInfo.Println("Dropped", dropped, "and refreshed", refreshed, "pending notification data for repository:", repository.RepoName)
+
+	return nil
+}
+
+// refreshIssue updates the snapshot with the current state of the issue and reports whether the issue
+// still qualifies to be notified to the user: it must be open, not assigned since the snapshot was
+// taken and still match one of the subscription rules of the user with its current labels and assignees
+func (m *issueMatcher) refreshIssue(userID uuid.UUID, snapshot models.Issue, current *events.Issue) (models.Issue, bool) {
+	if current.State != "open" || len(current.Assignees) > snapshot.AssigneesCount {
+		return snapshot, false
+	}
+
+	labelNames := make([]string, 0, len(current.Labels))
+	for _, l := range current.Labels {
+		labelNames = append(labelNames, l.Name)
+	}
+	if !m.matchesUser(userID, labelNames, len(current.Assignees)) {
+		return snapshot, false
+	}
+
+	refreshed := newIssue(current)
+	for i, l := range refreshed.Labels {
+		refreshed.Labels[i].Pattern, refreshed.Labels[i].IsOfInterest = m.patternOfInterest(userID, l.Name)
+	}
+
+	return refreshed, true
+}
+
+// fetchCurrentIssues returns the current state of the pending issues which changed since the oldest
+// snapshot. Sources which can list the updated issues in a few requests are used that way, otherwise
+// the pending issues are fetched one at a time. The issues whose snapshot has no update time, or all
+// the ones left out of a truncated listing, are fetched one at a time as well.
+func fetchCurrentIssues(ctx context.Context, repository services.Repository, pending []models.PendingNotification) (map[int]*events.Issue, error) {
+	source, err := sources.For(repository)
+	if err != nil {
+		return nil, fmt.Errorf("[fetchCurrentIssues]: %v", err)
+	}
+
+	issues := make(map[int]*events.Issue)
+	toFetch := pending
+	if lister, ok := source.(sources.IssueLister); ok {
+		var since time.Time
+		var undated []models.PendingNotification
+		for _, pn := range pending {
+			updatedAt, err := time.Parse(time.RFC3339, pn.Issue.UpdatedAt)
+			if err != nil {
+				undated = append(undated, pn)
+				continue
+			}
+			if since.IsZero() || updatedAt.Before(since) {
+				since = updatedAt
+			}
+		}
+
+		toFetch = undated
+		if !since.IsZero() {
+			listed, truncated, err := lister.ListIssuesUpdatedSince(ctx, repository, since)
+			if err != nil {
+				return nil, fmt.Errorf("[fetchCurrentIssues]: %v", err)
+			}
+			issues = listed
+
+			if truncated {
+				utils.LogInfo.Println("Updated issues listing got truncated for repository:", repository.RepoName, ". Fetching the remaining pending issues one at a time")
+				toFetch = pending
+			}
+		}
+	}
+
+	for _, pn := range toFetch {
+		number := int(pn.Issue.Number)
+		if _, exists := issues[number]; exists {
+			continue
+		}
+
+		issue, err := source.GetIssue(ctx, repository, number)
+		if err != nil {
+			return nil, fmt.Errorf("[fetchCurrentIssues]: %v", err)
+		}
+		issues[number] = issue
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// Kinds of the recorded runs: a cycle runs every job one after the other while the scheduled jobs
+// are recorded as runs of their own, and the repositories polled continuously as a run per tick
+const (
+	cycleRunKind = "cycle"
+	fetchRunKind = "fetch"
+	sendRunKind  = "send"
+	pollRunKind  = "poll"
+)
+
+// beginRun records the start of a run of the given kind and returns its ID, or 0 if it could not be
+// recorded in which case the outcomes of the run are only logged
+func beginRun(kind string) int64 {
+	runID, err := models.CreateRun(kind)
+	if err != nil {
+		utils.LogError.Println("Failed to record the start of a", kind, "run. Error:", err)
+		return 0
+	}
+	utils.LogInfo.Println("Started", kind, "run:", runID)
+
+	return runID
+}
+
+// finishRun records the end of the run along with its totals
+func finishRun(runID int64) {
+	if runID == 0 {
+		return
+	}
+
+	err := models.FinishRun(runID)
+	if err != nil {
+		utils.LogError.Println("Failed to record the end of run:", runID, ". Error:", err)
+		return
+	}
+	utils.LogInfo.Println("Finished run:", runID)
+}
+
+// recordRunError records an error of the run which is not specific to a repository or a user
+func recordRunError(runID int64, runErr error) {
+	if runID == 0 {
+		return
+	}
+
+	err := models.AddRunError(runID, runErr)
+	if err != nil {
+		utils.LogError.Println("Failed to record an error of run:", runID, ". Error:", err)
+	}
+}
+
+// recordPendingRepositories records the repositories the run is about to fetch, so that the ones
+// which did not get fetched before the run finished are recorded as timed out
+func recordPendingRepositories(runID int64, repositories []services.Repository) {
+	for _, repository := range repositories {
+		saveRunRepository(runID, models.RunRepository{RepoID: repository.RepoID, RepoName: repository.RepoName, Status: models.RunPending})
+	}
+}
+
+// recordRepository records the outcome of fetching the repository
+func recordRepository(runID int64, repository services.Repository, outcome fetchOutcome, fetchErr error) {
+	r := models.RunRepository{
+		RepoID:               repository.RepoID,
+		RepoName:             repository.RepoName,
+		Status:               models.RunSucceeded,
+		EventsScanned:        outcome.events,
+		IssuesMatched:        outcome.issues,
+		NotificationsCreated: outcome.notifications,
+	}
+	if fetchErr != nil {
+		r.Status = models.RunFailed
+		r.Error = fetchErr.Error()
+	}
+
+	saveRunRepository(runID, r)
+}
+
+func saveRunRepository(runID int64, r models.RunRepository) {
+	if runID == 0 {
+		return
+	}
+
+	r.RunID = runID
+	err := models.UpsertRunRepository(r)
+	if err != nil {
+		utils.LogError.Println("Failed to record the outcome of repository:", r.RepoName, "for run:", runID, ". Error:", err)
+	}
+}
+
+// recordPendingUsers records the users the run is about to send the digest to, so that the ones who
+// did not get it before the run finished are recorded as timed out
+func recordPendingUsers(runID int64, users []models.User) {
+	for _, user := range users {
+		saveRunUser(runID, models.RunUser{UserID: user.UserID, Username: user.Username, Status: models.RunPending})
+	}
+}
+
+// recordUser records the outcome of sending the digest of the given number of issues to the user
+func recordUser(runID int64, user models.User, issues int, sendErr error) {
+	u := models.RunUser{
+		UserID:   user.UserID,
+		Username: user.Username,
+		Status:   models.RunSucceeded,
+		Issues:   issues,
+	}
+	if sendErr != nil {
+		u.Status = models.RunFailed
+		u.Error = sendErr.Error()
+	}
+
+	saveRunUser(runID, u)
+}
+
+func saveRunUser(runID int64, u models.RunUser) {
+	if runID == 0 {
+		return
+	}
+
+	u.RunID = runID
+	err := models.UpsertRunUser(u)
+	if err != nil {
+		utils.LogError.Println("Failed to record the outcome of user:", u.Username, "for run:", runID, ". Error:", err)
+	}
+}
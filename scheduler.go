@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// schedulerTick is how often the scheduler looks for repositories due to be polled
+const schedulerTick = time.Minute
+
+// requestsPerPoll is a conservative estimate of the GitHub requests a poll costs, used to spread
+// the polls within the rate limit budget. Polls of unchanged repositories cost none as they are
+// answered with `304 Not Modified`.
+const requestsPerPoll = 2
+
+// pollScheduler polls each repository continuously on its own interval. The interval of a repository
+// is halved every time it has new events and doubled every time it has none, within
+// [minInterval, maxInterval]. Repositories with many subscriptions get a lower maximum interval so
+// that they are never polled as rarely as the quiet repositories nobody subscribed to.
+type pollScheduler struct {
+	minInterval time.Duration
+	maxInterval time.Duration
+	concurrency int
+
+	mu     sync.Mutex
+	states map[uuid.UUID]*pollState
+	// refreshedAt is when the tracked repositories were last fetched from the API
+	refreshedAt time.Time
+}
+
+// pollState is the schedule of a single repository
+type pollState struct {
+	repository services.Repository
+	interval   time.Duration
+	nextPollAt time.Time
+	polling    bool
+}
+
+// newPollScheduler returns a pollScheduler polling each repository between every minInterval and
+// every maxInterval, with at most `concurrency` polls at a time
+func newPollScheduler(minInterval, maxInterval time.Duration, concurrency int) *pollScheduler {
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &pollScheduler{
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		concurrency: concurrency,
+		states:      make(map[uuid.UUID]*pollState),
+	}
+}
+
+// run polls the repositories until the context is cancelled
+func (s *pollScheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	sem := make(chan struct{}, s.concurrency)
+	for {
+		s.refresh(time.Now())
+
+		if due := s.due(time.Now()); len(due) > 0 {
+			s.pollAll(ctx, sem, due)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollAll polls the due repositories, at most as many at a time as the semaphore allows, and records
+// them as a single `poll` run which finishes once all of them got polled. The repositories which were
+// not polled before the context got cancelled are recorded as timed out.
+func (s *pollScheduler) pollAll(ctx context.Context, sem chan struct{}, due []*pollState) {
+	runID := beginRun(pollRunKind)
+	repositories := make([]services.Repository, 0, len(due))
+	for _, state := range due {
+		repositories = append(repositories, state.repository)
+	}
+	recordPendingRepositories(runID, repositories)
+
+	var wg sync.WaitGroup
+polls:
+	for _, state := range due {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break polls
+		}
+
+		wg.Add(1)
+		inFlight.Add(1)
+		go func(state *pollState) {
+			defer inFlight.Done()
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.poll(ctx, runID, state)
+		}(state)
+	}
+
+	inFlight.Add(1)
+	go func() {
+		defer inFlight.Done()
+		wg.Wait()
+		finishRun(runID)
+	}()
+}
+
+// refresh adds the newly tracked repositories and removes the untracked ones. New repositories are
+// spread evenly over the minimum interval so that they are not all polled at once. The repositories
+// are fetched without holding the lock, so that the polls in progress can reschedule meanwhile.
+func (s *pollScheduler) refresh(now time.Time) {
+	s.mu.Lock()
+	refreshed := now.Sub(s.refreshedAt) < s.minInterval
+	s.mu.Unlock()
+	if refreshed {
+		return
+	}
+
+	repositories, err := services.GetAllRepositories()
+	if err != nil {
+		utils.LogError.Println("Failed to get all repositories. Error:", err)
+		return
+	}
+	s.update(now, repositories)
+}
+
+// update replaces the tracked repositories with the given ones
+func (s *pollScheduler) update(now time.Time, repositories []services.Repository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshedAt = now
+
+	tracked := make(map[uuid.UUID]bool, len(repositories))
+	added := 0
+	for i, repository := range repositories {
+		tracked[repository.RepoID] = true
+
+		if state, exists := s.states[repository.RepoID]; exists {
+			state.repository = repository
+			continue
+		}
+
+		s.states[repository.RepoID] = &pollState{
+			repository: repository,
+			interval:   s.minInterval,
+			nextPollAt: now.Add(s.minInterval * time.Duration(i) / time.Duration(len(repositories))),
+		}
+		added++
+	}
+
+	for repoID := range s.states {
+		if !tracked[repoID] {
+			delete(s.states, repoID)
+		}
+	}
+
+	if added > 0 {
+		utils.LogInfo.Println("Scheduling", added, "new repositories out of", len(repositories), "repositories")
+	}
+}
+
+// due returns the repositories whose poll is due, the most overdue first. GitHub repositories are
+// only polled as often as the rate limit budget of their host allows until it resets, the others
+// are postponed to a later tick.
+func (s *pollScheduler) due(now time.Time) []*pollState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*pollState
+	for _, state := range s.states {
+		if !state.polling && !now.Before(state.nextPollAt) {
+			due = append(due, state)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].nextPollAt.Before(due[j].nextPollAt)
+	})
+
+	budgets := make(map[string]int)
+	allowed := due[:0]
+	for _, state := range due {
+		if state.repository.Provider == services.ProviderGitHub {
+			host := state.repository.Host()
+			if _, exists := budgets[host]; !exists {
+				budgets[host] = pollBudget(githubClients[host], now)
+			}
+			if budgets[host] == 0 {
+				continue
+			}
+			if budgets[host] > 0 {
+				budgets[host]--
+			}
+		}
+
+		state.polling = true
+		allowed = append(allowed, state)
+	}
+
+	if postponed := len(due) - len(allowed); postponed > 0 {
+		utils.LogInfo.Println("Postponing", postponed, "due repositories to stay within the GitHub rate limit")
+	}
+
+	return allowed
+}
+
+// pollBudget returns how many repositories of the client can be polled during this tick so that its
+// remaining requests last until they reset, -1 if there is no known limit
+func pollBudget(client *github.Client, now time.Time) int {
+	if client == nil {
+		return -1
+	}
+
+	remaining, resetAt, known := client.Budget()
+	if !known {
+		return -1
+	}
+
+	if remaining < requestsPerPoll {
+		return 0
+	}
+
+	// Rounded up so that a budget smaller than a poll per tick still allows a poll
+	ticksLeft := int(resetAt.Sub(now)/schedulerTick) + 1
+	return (remaining + ticksLeft*requestsPerPoll - 1) / (ticksLeft * requestsPerPoll)
+}
+
+// poll fetches the new issue events of the repository, records the outcome for the run and schedules
+// its next poll from it
+func (s *pollScheduler) poll(ctx context.Context, runID int64, state *pollState) {
+	outcome, err := processIssueEvents(ctx, state.repository, nil)
+	recordRepository(runID, state.repository, outcome, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state.polling = false
+	now := time.Now()
+
+	var rateLimitErr *github.RateLimitError
+	switch {
+	case errors.As(err, &rateLimitErr):
+		state.nextPollAt = rateLimitErr.RetryAt
+		utils.LogInfo.Println("Rescheduling repository:", state.repository.RepoName, "to:", state.nextPollAt, "as GitHub rate limit is exhausted")
+		return
+	case err != nil:
+		utils.LogError.Println("Failed to poll repository:", state.repository.RepoName, ". Error:", err)
+	case outcome.events > 0:
+		state.interval /= 2
+	default:
+		state.interval *= 2
+	}
+
+	state.interval = s.clampInterval(state.interval, outcome.subscriptions)
+	state.nextPollAt = now.Add(state.interval)
+	utils.LogInfo.Println("Next poll of repository:", state.repository.RepoName, "in:", state.interval, "after", outcome.events, "new issue events")
+}
+
+// clampInterval keeps the interval within the bounds of a repository with the given number of
+// subscriptions. Each 10 subscriptions lower the maximum interval, down to a quarter of it.
+func (s *pollScheduler) clampInterval(interval time.Duration, subscriptions int) time.Duration {
+	weight := 1 + subscriptions/10
+	if weight > 4 {
+		weight = 4
+	}
+
+	maxInterval := s.maxInterval / time.Duration(weight)
+	if maxInterval < s.minInterval {
+		maxInterval = s.minInterval
+	}
+
+	if interval < s.minInterval {
+		return s.minInterval
+	}
+	if interval > maxInterval {
+		return maxInterval
+	}
+
+	return interval
+}
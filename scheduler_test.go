@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/services"
+)
+
+// clientWithBudget returns a client whose single token has the given requests left until the reset
+func clientWithBudget(t *testing.T, remaining int, resetIn time.Duration) *github.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(resetIn).Unix(), 10))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(server.URL, []string{"a"}, 0)
+	req, _ := http.NewRequest("GET", server.URL+"/rate_limit", nil)
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	res.Body.Close()
+
+	return client
+}
+
+func TestPollBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		client func(t *testing.T) *github.Client
+		want   int
+	}{
+		{
+			name:   "no client",
+			client: func(t *testing.T) *github.Client { return nil },
+			want:   -1,
+		},
+		{
+			name:   "rate limit not seen yet",
+			client: func(t *testing.T) *github.Client { return github.NewClient("", []string{"a"}, 0) },
+			want:   -1,
+		},
+		{
+			name:   "less than a poll left",
+			client: func(t *testing.T) *github.Client { return clientWithBudget(t, requestsPerPoll-1, time.Hour) },
+			want:   0,
+		},
+		{
+			name:   "remaining requests spread until the reset",
+			client: func(t *testing.T) *github.Client { return clientWithBudget(t, 100, 10*time.Minute+30*time.Second) },
+			want:   5,
+		},
+		{
+			name:   "budget smaller than a poll per tick still allows a poll",
+			client: func(t *testing.T) *github.Client { return clientWithBudget(t, 3, time.Hour) },
+			want:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pollBudget(tt.client(t), time.Now()); got != tt.want {
+				t.Errorf("pollBudget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollSchedulerClampInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		minInterval   time.Duration
+		interval      time.Duration
+		subscriptions int
+		want          time.Duration
+	}{
+		{"below the minimum", time.Minute, 10 * time.Second, 0, time.Minute},
+		{"within the bounds", time.Minute, 30 * time.Minute, 0, 30 * time.Minute},
+		{"above the maximum", time.Minute, 2 * time.Hour, 0, time.Hour},
+		{"subscriptions lower the maximum", time.Minute, 2 * time.Hour, 25, 20 * time.Minute},
+		{"maximum lowered to a quarter at most", time.Minute, 2 * time.Hour, 100, 15 * time.Minute},
+		{"lowered maximum stays above the minimum", 20 * time.Minute, 2 * time.Hour, 100, 20 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPollScheduler(tt.minInterval, time.Hour, 1)
+			if got := s.clampInterval(tt.interval, tt.subscriptions); got != tt.want {
+				t.Errorf("clampInterval(%v, %v) = %v, want %v", tt.interval, tt.subscriptions, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollSchedulerUpdate(t *testing.T) {
+	now := time.Now()
+	s := newPollScheduler(4*time.Minute, time.Hour, 1)
+
+	kept := services.Repository{RepoID: uuid.New(), RepoName: "o/kept"}
+	untracked := services.Repository{RepoID: uuid.New(), RepoName: "o/untracked"}
+	s.update(now, []services.Repository{kept, untracked})
+	s.states[kept.RepoID].interval = 30 * time.Minute
+
+	repositories := []services.Repository{kept}
+	for i := 0; i < 3; i++ {
+		repositories = append(repositories, services.Repository{RepoID: uuid.New(), RepoName: "o/new" + strconv.Itoa(i)})
+	}
+	s.update(now.Add(time.Minute), repositories)
+
+	if len(s.states) != 4 {
+		t.Fatalf("states = %d, want the 4 tracked repositories", len(s.states))
+	}
+	if _, exists := s.states[untracked.RepoID]; exists {
+		t.Error("untracked repository is still scheduled")
+	}
+	if s.states[kept.RepoID].interval != 30*time.Minute {
+		t.Errorf("interval of the kept repository = %v, want its schedule kept", s.states[kept.RepoID].interval)
+	}
+
+	// The new repositories are spread over the minimum interval, by their position
+	for i, repository := range repositories[1:] {
+		want := now.Add(time.Minute).Add(time.Duration(i+1) * time.Minute)
+		if got := s.states[repository.RepoID].nextPollAt; !got.Equal(want) {
+			t.Errorf("nextPollAt of %v = %v, want %v", repository.RepoName, got, want)
+		}
+	}
+}
+
+func TestPollSchedulerDue(t *testing.T) {
+	now := time.Now()
+	limited := services.Repository{RepoID: uuid.New(), RepoName: "o/limited", Provider: services.ProviderGitHub, BaseURL: "https://limited.example.com"}
+
+	previous := githubClients
+	githubClients = map[string]*github.Client{limited.Host(): clientWithBudget(t, 0, time.Hour)}
+	defer func() { githubClients = previous }()
+
+	newState := func(name string, repository services.Repository, nextPollIn time.Duration, polling bool) *pollState {
+		repository.RepoID = uuid.New()
+		repository.RepoName = name
+		return &pollState{repository: repository, interval: time.Minute, nextPollAt: now.Add(nextPollIn), polling: polling}
+	}
+	gitlab := services.Repository{Provider: services.ProviderGitLab, BaseURL: "https://gitlab.com"}
+
+	s := newPollScheduler(time.Minute, time.Hour, 1)
+	for _, state := range []*pollState{
+		newState("o/due", gitlab, -time.Minute, false),
+		newState("o/most-overdue", gitlab, -time.Hour, false),
+		newState("o/not-due", gitlab, time.Minute, false),
+		newState("o/polling", gitlab, -time.Hour, true),
+		newState("o/rate-limited", limited, -time.Hour, false),
+	} {
+		s.states[state.repository.RepoID] = state
+	}
+
+	due := s.due(now)
+
+	var names []string
+	for _, state := range due {
+		names = append(names, state.repository.RepoName)
+		if !state.polling {
+			t.Errorf("repository %v is not marked as polling", state.repository.RepoName)
+		}
+	}
+	if want := []string{"o/most-overdue", "o/due"}; len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("due() = %v, want %v", names, want)
+	}
+	if again := s.due(now); len(again) != 0 {
+		t.Errorf("due() = %d repositories, want none as the due ones are being polled", len(again))
+	}
+}
@@ -5,6 +5,22 @@ var (
 	IssueNotifierAPIEndpoint string
 )
 
+// defaultBaseURLs holds the base URL of the repositories of each provider which do not carry their own
+var defaultBaseURLs = map[string]string{
+	ProviderGitHub: "https://github.com",
+	ProviderGitLab: "https://gitlab.com",
+}
+
+// SetDefaultBaseURL sets the base URL of the repositories of the given provider which do not carry their own
+func SetDefaultBaseURL(provider, baseURL string) {
+	defaultBaseURLs[provider] = baseURL
+}
+
+// DefaultBaseURL returns the base URL of the repositories of the given provider which do not carry their own
+func DefaultBaseURL(provider string) string {
+	return defaultBaseURLs[provider]
+}
+
 // Init initializes the IssueNotifierAPIEndpoint endpoint from the .env file
 func Init(issueNotifierAPIEndpoint string) {
 	IssueNotifierAPIEndpoint = issueNotifierAPIEndpoint
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,6 +17,15 @@ import (
 var layout1 string = "2006-01-02T15:04:05-07:00"
 var layout2 string = "2006-01-02T15:04:05Z"
 
+// Providers which host the repositories
+const (
+	ProviderGitHub string = "github"
+	ProviderGitLab string = "gitlab"
+	ProviderGitea  string = "gitea"
+	// Forgejo is a fork of Gitea with the same API
+	ProviderForgejo string = "forgejo"
+)
+
 type lastEventAtStruct struct {
 	LastEventAt time.Time `json:"lastEventAt" db:"last_event_at"`
 }
@@ -24,6 +35,32 @@ type Repository struct {
 	RepoID      uuid.UUID `json:"repoID" db:"repo_id"`
 	RepoName    string    `json:"repoName" db:"repo_name"`
 	LastEventAt time.Time `json:"lastEventAt" db:"last_event_at"`
+	Provider    string    `json:"provider" db:"provider"`
+	BaseURL     string    `json:"baseURL" db:"base_url"`
+}
+
+// Host returns the host of the repository, e.g. `github.com` or the host of a GitHub Enterprise Server
+func (r Repository) Host() string {
+	u, err := url.Parse(r.BaseURL)
+	if err != nil || u.Host == "" {
+		return r.BaseURL
+	}
+
+	return u.Host
+}
+
+// WebURL returns the URL of the repository's web page
+func (r Repository) WebURL() string {
+	return strings.TrimSuffix(r.BaseURL, "/") + "/" + r.RepoName
+}
+
+// IssueURL returns the URL of the web page of the given issue of the repository
+func (r Repository) IssueURL(number float64) string {
+	if r.Provider == ProviderGitLab {
+		return r.WebURL() + "/-/issues/" + strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	return r.WebURL() + "/issues/" + strconv.FormatFloat(number, 'f', -1, 64)
 }
 
 // Label struct to store label information from database
@@ -31,6 +68,8 @@ type Label struct {
 	Name         string `json:"name" db:"label_name"`
 	Color        string `json:"color" db:"label_color"`
 	IsOfInterest bool   `json:"isOfInterest" db:"is_of_interest"`
+	// Pattern is the subscribed label pattern which made the label of interest
+	Pattern string `json:"pattern,omitempty" db:"pattern"`
 }
 
 // GetTextColor returns font text color based on label background color
@@ -79,10 +118,21 @@ func GetAllRepositories() ([]Repository, error) {
 				return nil, fmt.Errorf("Failed to parse time `lastEventAt`: %v with layout %v or %v", r["lastEventAt"].(string), layout1, layout2)
 			}
 		}
+		// Repositories without a provider are the ones tracked before other providers were supported
+		provider, _ := r["provider"].(string)
+		if provider == "" {
+			provider = ProviderGitHub
+		}
+		baseURL, _ := r["baseURL"].(string)
+		if baseURL == "" {
+			baseURL = DefaultBaseURL(provider)
+		}
 		repositories = append(repositories, Repository{
 			RepoID:      repoID,
 			RepoName:    r["repoName"].(string),
 			LastEventAt: lastEventAt,
+			Provider:    provider,
+			BaseURL:     baseURL,
 		})
 	}
 
@@ -0,0 +1,208 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// giteaPageSize is the default maximum number of items Gitea returns per page
+const giteaPageSize = 50
+
+// GiteaSource is the IssueSource of repositories hosted on self-hosted Gitea or Forgejo instances,
+// backed by the issue timeline API. Each repository carries the base URL of its instance and the
+// token is looked up per repository, falling back to the one of its instance.
+type GiteaSource struct {
+	// tokens are keyed by `host/owner/name` for a single repository or by `host` for a whole instance
+	tokens     map[string]string
+	httpClient *http.Client
+}
+
+// giteaTimelineComment struct defines an item of the issue timeline as returned by the Gitea API
+type giteaTimelineComment struct {
+	ID              int64         `json:"id"`
+	Type            string        `json:"type"`
+	Body            string        `json:"body"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Label           *events.Label `json:"label"`
+	Assignee        *events.User  `json:"assignee"`
+	RemovedAssignee bool          `json:"removed_assignee"`
+}
+
+// NewGiteaSource returns a GiteaSource which authenticates with the given tokens
+func NewGiteaSource(tokens map[string]string) *GiteaSource {
+	return &GiteaSource{
+		tokens:     tokens,
+		httpClient: &http.Client{},
+	}
+}
+
+// ListEvents returns the label, close and assignee changes of all the issues of the repository
+// updated since the cursor
+func (s *GiteaSource) ListEvents(ctx context.Context, repository services.Repository, cursor Cursor) (*EventList, error) {
+	list := newEventList(cursor)
+
+	query := url.Values{}
+	query.Set("state", "all")
+	query.Set("type", "issues")
+	if !cursor.Since.IsZero() {
+		query.Set("since", cursor.Since.UTC().Format(time.RFC3339))
+	}
+
+	var issues []*events.Issue
+	err := s.paginate(ctx, repository, "/issues", query, func(dataBytes []byte) (int, error) {
+		page, err := events.DecodeIssues(dataBytes)
+		for _, issue := range page {
+			trimLabelColors(issue)
+		}
+		issues = append(issues, page...)
+		return len(page), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[ListEvents]: %v", err)
+	}
+
+	for _, issue := range issues {
+		timelineQuery := url.Values{}
+		if !cursor.Since.IsZero() {
+			timelineQuery.Set("since", cursor.Since.UTC().Format(time.RFC3339))
+		}
+
+		var comments []giteaTimelineComment
+		err := s.paginate(ctx, repository, "/issues/"+strconv.Itoa(issue.Number)+"/timeline", timelineQuery, func(dataBytes []byte) (int, error) {
+			var page []giteaTimelineComment
+			err := json.Unmarshal(dataBytes, &page)
+			comments = append(comments, page...)
+			return len(page), err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[ListEvents]: %v", err)
+		}
+
+		for _, c := range comments {
+			e := events.Event{
+				ID:        c.ID,
+				CreatedAt: c.CreatedAt,
+				Issue:     issue,
+				Label:     c.Label,
+				Assignee:  c.Assignee,
+			}
+			switch {
+			// A label comment has a body of `1` when the label got added and an empty one when removed
+			case c.Type == "label" && c.Body == "1":
+				e.Event = "labeled"
+			case c.Type == "label":
+				e.Event = "unlabeled"
+			case c.Type == "close":
+				e.Event = "closed"
+			case c.Type == "assignees" && !c.RemovedAssignee:
+				e.Event = "assigned"
+			case c.Type == "assignees":
+				e.Event = "unassigned"
+			default:
+				continue
+			}
+			if e.Label != nil {
+				e.Label.Color = strings.TrimPrefix(e.Label.Color, "#")
+			}
+
+			list.addEvent(e, repository)
+		}
+	}
+
+	list.sortEvents()
+	utils.LogInfo.Println("Fetched", len(list.Events), "issue events of", len(issues), "updated issues for repository:", repository.RepoName)
+
+	return list, nil
+}
+
+// GetIssue returns the current state of an issue of the repository
+func (s *GiteaSource) GetIssue(ctx context.Context, repository services.Repository, number int) (*events.Issue, error) {
+	dataBytes, err := s.get(ctx, repository, "/issues/"+strconv.Itoa(number))
+	if err != nil {
+		return nil, fmt.Errorf("[GetIssue]: %v", err)
+	}
+
+	issue, err := events.DecodeIssue(dataBytes)
+	if err != nil {
+		return nil, fmt.Errorf("[GetIssue]: %v", err)
+	}
+	trimLabelColors(issue)
+
+	return issue, nil
+}
+
+// trimLabelColors drops the leading `#` Gitea gives the label colors of an issue, which GitHub does not
+func trimLabelColors(issue *events.Issue) {
+	for _, l := range issue.Labels {
+		l.Color = strings.TrimPrefix(l.Color, "#")
+	}
+}
+
+// paginate calls handle with each page of the given list endpoint until an empty or short page is returned
+func (s *GiteaSource) paginate(ctx context.Context, repository services.Repository, endpoint string, query url.Values, handle func([]byte) (int, error)) error {
+	query.Set("limit", strconv.Itoa(giteaPageSize))
+	for pageNumber := 1; ; pageNumber++ {
+		query.Set("page", strconv.Itoa(pageNumber))
+		dataBytes, err := s.get(ctx, repository, endpoint+"?"+query.Encode())
+		if err != nil {
+			return err
+		}
+
+		count, err := handle(dataBytes)
+		if err != nil {
+			return err
+		}
+		if count < giteaPageSize {
+			return nil
+		}
+	}
+}
+
+func (s *GiteaSource) get(ctx context.Context, repository services.Repository, endpoint string) ([]byte, error) {
+	if repository.BaseURL == "" {
+		return nil, fmt.Errorf("No base URL configured for repository %v", repository.RepoName)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(repository.BaseURL, "/")+"/api/v1/repos/"+repository.RepoName+endpoint, nil)
+	if token := s.token(repository); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	dataBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Received %v from %v with message %v", res.Status, repository.BaseURL, string(dataBytes))
+	}
+
+	return dataBytes, nil
+}
+
+// token returns the token of the repository, or the one of its instance if it has none
+func (s *GiteaSource) token(repository services.Repository) string {
+	host := repository.BaseURL
+	if u, err := url.Parse(repository.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	if token, exists := s.tokens[host+"/"+repository.RepoName]; exists {
+		return token
+	}
+
+	return s.tokens[host]
+}
@@ -0,0 +1,81 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/services"
+)
+
+func TestGiteaSourceListEvents(t *testing.T) {
+	const issue = `{"number": 3, "title": "Bug", "state": "open", "labels": [{"name": "bug", "color": "#d73a4a"}], "assignees": []}`
+	responses := map[string]string{
+		"/api/v1/repos/o/r/issues": `[` + issue + `]`,
+		"/api/v1/repos/o/r/issues/3/timeline": `[
+			{"id": 11, "type": "comment", "body": "Looks like a bug", "created_at": "2021-01-01T10:05:00Z"},
+			{"id": 12, "type": "label", "body": "1", "created_at": "2021-01-01T10:10:00Z", "label": {"name": "good first issue", "color": "#7057ff"}},
+			{"id": 13, "type": "label", "body": "", "created_at": "2021-01-01T10:20:00Z", "label": {"name": "bug", "color": "#d73a4a"}},
+			{"id": 14, "type": "assignees", "created_at": "2021-01-01T10:30:00Z", "assignee": {"login": "octocat"}},
+			{"id": 15, "type": "assignees", "removed_assignee": true, "created_at": "2021-01-01T10:40:00Z", "assignee": {"login": "octocat"}},
+			{"id": 16, "type": "close", "created_at": "2021-01-01T10:50:00Z"}
+		]`,
+		"/api/v1/repos/o/r/issues/3": issue,
+	}
+
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		response, exists := responses[r.URL.Path]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	repository := services.Repository{RepoName: "o/r", Provider: services.ProviderGitea, BaseURL: server.URL}
+	source := NewGiteaSource(map[string]string{repository.Host(): "instance-token"})
+
+	list, err := source.ListEvents(context.Background(), repository, Cursor{Since: time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("ListEvents() error = %v", err)
+	}
+
+	want := []string{"labeled", "unlabeled", "assigned", "unassigned", "closed"}
+	if len(list.Events) != len(want) {
+		t.Fatalf("events = %d, want %v", len(list.Events), want)
+	}
+	for i, e := range list.Events {
+		if e.Event != want[i] {
+			t.Errorf("events[%d] = %v, want %v", i, e.Event, want[i])
+		}
+	}
+	if got := list.Events[0].Label.Color; got != "7057ff" {
+		t.Errorf("event label color = %q, want it without the leading #", got)
+	}
+	if got := list.Events[0].Issue.Labels[0].Color; got != "d73a4a" {
+		t.Errorf("issue label color = %q, want it without the leading #", got)
+	}
+	if list.Next.EventID != 16 {
+		t.Errorf("Next.EventID = %v, want the ID of the last event", list.Next.EventID)
+	}
+
+	current, err := source.GetIssue(context.Background(), repository, 3)
+	if err != nil {
+		t.Fatalf("GetIssue() error = %v", err)
+	}
+	if got := current.Labels[0].Color; got != "d73a4a" {
+		t.Errorf("GetIssue() label color = %q, want it without the leading #", got)
+	}
+
+	for _, authorization := range authorizations {
+		if authorization != "token instance-token" {
+			t.Errorf("Authorization = %q, want the token of the instance", authorization)
+		}
+	}
+}
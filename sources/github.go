@@ -0,0 +1,259 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// GitHubSource is the IssueSource of repositories hosted on GitHub or a GitHub Enterprise Server,
+// backed by the issue events API
+type GitHubSource struct {
+	// clients are keyed by the host of the repositories they make requests for
+	clients map[string]*github.Client
+	// MaxPages is the maximum number of issue events pages fetched per repository, 0 for no limit
+	MaxPages int
+}
+
+// NewGitHubSource returns a GitHubSource which makes its requests using the client of each host
+func NewGitHubSource(clients map[string]*github.Client, maxPages int) *GitHubSource {
+	return &GitHubSource{clients: clients, MaxPages: maxPages}
+}
+
+// clientFor returns the client for the host of the repository
+func (s *GitHubSource) clientFor(repository services.Repository) (*github.Client, error) {
+	client, exists := s.clients[repository.Host()]
+	if !exists {
+		return nil, fmt.Errorf("No GitHub credentials configured for host %v of repository %v", repository.Host(), repository.RepoName)
+	}
+
+	return client, nil
+}
+
+// ListEvents paginates through the issue events of the repository, newest first, following the `next`
+// links until it reaches the events older than the cursor. The first page is requested conditionally so
+// it costs no rate limit if nothing changed since the cursor.
+//
+// The list is marked as truncated if the cursor was not reached, either because MaxPages were fetched
+// or because GitHub does not return events that old anymore, as events may have been missed.
+func (s *GitHubSource) ListEvents(ctx context.Context, repository services.Repository, cursor Cursor) (*EventList, error) {
+	client, err := s.clientFor(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	list := newEventList(cursor)
+
+	var issueEvents []events.Event
+	pageNumber := 0
+	var oldestEventTime time.Time
+	var mostRecentEventTime time.Time
+	reachedCursor := false
+	nextURL := client.BaseURL + "/repos/" + repository.RepoName + "/issues/events?per_page=100"
+	for nextURL != "" {
+		if s.MaxPages > 0 && pageNumber == s.MaxPages {
+			break
+		}
+		pageNumber++
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
+		// Conditional requests which return `304 Not Modified` do not count against the rate limit
+		if pageNumber == 1 {
+			if cursor.ETag != "" {
+				req.Header.Set("If-None-Match", cursor.ETag)
+			}
+			if cursor.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cursor.LastModified)
+			}
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		dataBytes, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusNotModified {
+			list.NotModified = true
+			return list, nil
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Received %v from GitHub with message %v", res.Status, string(dataBytes))
+		}
+
+		data, skipped, err := events.Decode(dataBytes)
+		if err != nil {
+			return nil, fmt.Errorf("[ListEvents]: %v", err)
+		}
+		list.Stats.Add(len(data), skipped)
+		for _, s := range skipped {
+			utils.LogInfo.Println("Skipping issue event for repository:", repository.RepoName, ". Reason:", s)
+		}
+
+		if pageNumber == 1 {
+			list.Next.ETag = res.Header.Get("ETag")
+			list.Next.LastModified = res.Header.Get("Last-Modified")
+		}
+
+		if len(data) == 0 && len(skipped) == 0 {
+			reachedCursor = true
+			break
+		}
+
+		issueEvents = append(issueEvents, data...)
+		if len(data) > 0 && mostRecentEventTime.IsZero() {
+			mostRecentEventTime = data[0].CreatedAt
+		}
+
+		// The skipped events count too, a page of pull request events may be the one reaching the cursor
+		if pageOldest := oldestCreatedAt(data, skipped); !pageOldest.IsZero() {
+			oldestEventTime = pageOldest
+			if oldestEventTime.Before(cursor.Since) {
+				reachedCursor = true
+				break
+			}
+		}
+
+		nextURL = github.NextPageURL(res.Header)
+	}
+	utils.LogInfo.Println("Paginated up to:", pageNumber, "pages. Fetched events from:", oldestEventTime, "to:", mostRecentEventTime, "for repository:", repository.RepoName)
+
+	// A repository whose whole history is newer than the cursor also ends without reaching it. It
+	// is reported as well since the response does not tell it apart from an exhausted window.
+	if !reachedCursor && len(issueEvents) > 0 {
+		list.Truncated = true
+	}
+
+	for i := len(issueEvents) - 1; i >= 0; i-- {
+		if !cursor.Processed(issueEvents[i]) {
+			list.Events = append(list.Events, issueEvents[i])
+		}
+	}
+	if len(issueEvents) > 0 {
+		list.Next.Since = issueEvents[0].CreatedAt
+		list.Next.EventID = issueEvents[0].ID
+	}
+
+	return list, nil
+}
+
+// oldestCreatedAt returns the creation time of the oldest event of a page, decoded or skipped. It is
+// zero if none of the events has one.
+func oldestCreatedAt(data []events.Event, skipped []error) time.Time {
+	var oldest time.Time
+	consider := func(t time.Time) {
+		if !t.IsZero() && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+
+	for _, e := range data {
+		consider(e.CreatedAt)
+	}
+	for _, err := range skipped {
+		var vErr *events.ValidationError
+		if errors.As(err, &vErr) {
+			consider(vErr.CreatedAt)
+		}
+	}
+
+	return oldest
+}
+
+// GetIssue returns the current state of an issue of the repository
+func (s *GitHubSource) GetIssue(ctx context.Context, repository services.Repository, number int) (*events.Issue, error) {
+	client, err := s.clientFor(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", client.BaseURL+"/repos/"+repository.RepoName+"/issues/"+strconv.Itoa(number), nil)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	dataBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Received %v from GitHub with message %v", res.Status, string(dataBytes))
+	}
+
+	issue, err := events.DecodeIssue(dataBytes)
+	if err != nil {
+		return nil, fmt.Errorf("[GetIssue]: %v", err)
+	}
+
+	return issue, nil
+}
+
+// issueListMaxPages is the maximum number of pages of updated issues listed per repository
+const issueListMaxPages = 10
+
+// ListIssuesUpdatedSince returns the issues of the repository updated since the given time by their
+// number, most recently updated first. At most issueListMaxPages are fetched, the list is reported as
+// truncated if there were more.
+func (s *GitHubSource) ListIssuesUpdatedSince(ctx context.Context, repository services.Repository, since time.Time) (map[int]*events.Issue, bool, error) {
+	client, err := s.clientFor(repository)
+	if err != nil {
+		return nil, false, err
+	}
+
+	query := url.Values{}
+	query.Set("state", "all")
+	query.Set("sort", "updated")
+	query.Set("direction", "desc")
+	query.Set("per_page", "100")
+	if !since.IsZero() {
+		query.Set("since", since.UTC().Format(time.RFC3339))
+	}
+
+	issues := make(map[int]*events.Issue)
+	pageNumber := 0
+	nextURL := client.BaseURL + "/repos/" + repository.RepoName + "/issues?" + query.Encode()
+	for nextURL != "" {
+		if pageNumber == issueListMaxPages {
+			return issues, true, nil
+		}
+		pageNumber++
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, false, err
+		}
+
+		dataBytes, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, false, fmt.Errorf("Received %v from GitHub with message %v", res.Status, string(dataBytes))
+		}
+
+		page, err := events.DecodeIssues(dataBytes)
+		if err != nil {
+			return nil, false, fmt.Errorf("[ListIssuesUpdatedSince]: %v", err)
+		}
+
+		for _, issue := range page {
+			if !issue.IsPullRequest() {
+				issues[issue.Number] = issue
+			}
+		}
+
+		nextURL = github.NextPageURL(res.Header)
+	}
+
+	return issues, false, nil
+}
@@ -0,0 +1,341 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// Sizes of the connections requested per repository. They bound the cost of a query, and a
+// repository which has more updated issues or timeline items than fit falls back to the REST API.
+const (
+	graphqlIssuesPerRepository = 25
+	graphqlItemsPerIssue       = 20
+	graphqlLabelsPerIssue      = 20
+	graphqlAssigneesPerIssue   = 10
+)
+
+// GitHubGraphQL fetches the issue events of many GitHub repositories at once with the GraphQL API.
+// Each query asks for the timeline items of the recently updated issues of a batch of repositories,
+// using one alias per repository, so it costs a handful of points instead of several REST requests
+// per repository.
+type GitHubGraphQL struct {
+	// clients are keyed by the host of the repositories they make requests for
+	clients map[string]*github.Client
+	// BatchSize is the number of repositories queried at once
+	BatchSize int
+}
+
+// NewGitHubGraphQL returns a GitHubGraphQL which makes its requests using the client of each host
+func NewGitHubGraphQL(clients map[string]*github.Client, batchSize int) *GitHubGraphQL {
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+
+	return &GitHubGraphQL{clients: clients, BatchSize: batchSize}
+}
+
+// graphqlLabel struct defines a label as returned by the GraphQL API
+type graphqlLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// graphqlIssue struct defines an issue as returned by the query
+type graphqlIssue struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Assignees struct {
+		Nodes []*events.User `json:"nodes"`
+	} `json:"assignees"`
+	Labels struct {
+		Nodes []graphqlLabel `json:"nodes"`
+	} `json:"labels"`
+	TimelineItems struct {
+		PageInfo struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pageInfo"`
+		Nodes []struct {
+			Typename  string        `json:"__typename"`
+			ID        string        `json:"id"`
+			CreatedAt time.Time     `json:"createdAt"`
+			Label     *graphqlLabel `json:"label"`
+			Assignee  *events.User  `json:"assignee"`
+		} `json:"nodes"`
+	} `json:"timelineItems"`
+}
+
+// graphqlRepository struct defines a repository as returned by the query
+type graphqlRepository struct {
+	Issues struct {
+		PageInfo struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pageInfo"`
+		Nodes []graphqlIssue `json:"nodes"`
+	} `json:"issues"`
+}
+
+// graphqlTimelineEvents maps the timeline item types to the issue events API event names
+var graphqlTimelineEvents = map[string]string{
+	"LabeledEvent":     "labeled",
+	"UnlabeledEvent":   "unlabeled",
+	"ClosedEvent":      "closed",
+	"AssignedEvent":    "assigned",
+	"UnassignedEvent":  "unassigned",
+	"TransferredEvent": "transferred",
+}
+
+// ListEventsBatch returns the event list of each of the given repositories by their ID. All the
+// repositories must be hosted on GitHub. Repositories missing from the result could not be fetched
+// completely with GraphQL, either because they had too much activity or because the rate limit ran
+// out, and must be fetched with the REST API instead.
+func (g *GitHubGraphQL) ListEventsBatch(ctx context.Context, repositories []services.Repository, cursors map[uuid.UUID]Cursor) map[uuid.UUID]*EventList {
+	lists := make(map[uuid.UUID]*EventList, len(repositories))
+
+	// The batches sharing a rate limit which is almost exhausted, by their key
+	exhausted := make(map[string]bool)
+	for _, batch := range g.batches(repositories) {
+		key := g.batchKey(batch[0])
+		if exhausted[key] {
+			continue
+		}
+
+		client, exists := g.clients[batch[0].Host()]
+		if !exists {
+			continue
+		}
+
+		batchLists, remaining, resetAt, err := g.query(ctx, client, batch, cursors)
+		if err != nil {
+			utils.LogError.Println("Failed to fetch issue events of", len(batch), "repositories with GraphQL. Error:", err)
+			continue
+		}
+		for repoID, list := range batchLists {
+			lists[repoID] = list
+		}
+
+		// Leave the rest of the repositories sharing the rate limit to the REST API rather than running
+		// out of points, the batches of the other hosts or installations have a rate limit of their own
+		if remaining < estimateGraphQLCost(g.BatchSize) {
+			utils.LogInfo.Println("GraphQL rate limit of:", key, "is almost exhausted until:", resetAt, "falling back to the REST API for the rest of its repositories")
+			exhausted[key] = true
+		}
+	}
+
+	return lists
+}
+
+// batchKey returns the key of the batches the repository can be queried with, which share a rate
+// limit. When authenticating as a GitHub App a batch only holds repositories of the same owner, as a
+// query is made with the token of a single installation.
+func (g *GitHubGraphQL) batchKey(repository services.Repository) string {
+	key := repository.Host()
+	if client, exists := g.clients[key]; exists && client.IsApp() {
+		key += "/" + strings.SplitN(repository.RepoName, "/", 2)[0]
+	}
+
+	return key
+}
+
+// batches splits the repositories in batches of repositories of the same key
+func (g *GitHubGraphQL) batches(repositories []services.Repository) [][]services.Repository {
+	groups := make(map[string][]services.Repository)
+	var keys []string
+	for _, repository := range repositories {
+		key := g.batchKey(repository)
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], repository)
+	}
+
+	var batches [][]services.Repository
+	for _, key := range keys {
+		group := groups[key]
+		for len(group) > g.BatchSize {
+			batches = append(batches, group[:g.BatchSize])
+			group = group[g.BatchSize:]
+		}
+		batches = append(batches, group)
+	}
+
+	return batches
+}
+
+// query fetches a single batch of repositories and returns the event lists of the ones which fit in
+// the query, along with the remaining GraphQL rate limit
+func (g *GitHubGraphQL) query(ctx context.Context, client *github.Client, batch []services.Repository, cursors map[uuid.UUID]Cursor) (map[uuid.UUID]*EventList, int, time.Time, error) {
+	var query strings.Builder
+	query.WriteString("query {\n")
+	for i, repository := range batch {
+		parts := strings.SplitN(repository.RepoName, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		since := cursors[repository.RepoID].Since.UTC().Format(time.RFC3339)
+
+		fmt.Fprintf(&query, `r%d: repository(owner: %s, name: %s) {
+  issues(first: %d, orderBy: {field: UPDATED_AT, direction: DESC}, filterBy: {since: "%s"}) {
+    pageInfo { hasNextPage }
+    nodes {
+      number title state createdAt updatedAt
+      assignees(first: %d) { nodes { login } }
+      labels(first: %d) { nodes { name color } }
+      timelineItems(first: %d, since: "%s", itemTypes: [LABELED_EVENT, UNLABELED_EVENT, CLOSED_EVENT, ASSIGNED_EVENT, UNASSIGNED_EVENT, TRANSFERRED_EVENT]) {
+        pageInfo { hasNextPage }
+        nodes {
+          __typename
+          ... on LabeledEvent { id createdAt label { name color } }
+          ... on UnlabeledEvent { id createdAt label { name color } }
+          ... on ClosedEvent { id createdAt }
+          ... on AssignedEvent { id createdAt assignee { ... on User { login } ... on Bot { login } } }
+          ... on UnassignedEvent { id createdAt assignee { ... on User { login } ... on Bot { login } } }
+          ... on TransferredEvent { id createdAt }
+        }
+      }
+    }
+  }
+}
+`, i, strconv.Quote(parts[0]), strconv.Quote(parts[1]), graphqlIssuesPerRepository, since, graphqlAssigneesPerIssue, graphqlLabelsPerIssue, graphqlItemsPerIssue, since)
+	}
+	query.WriteString("rateLimit { cost remaining resetAt }\n}")
+
+	reqBody, _ := json.Marshal(map[string]string{"query": query.String()})
+	req, _ := http.NewRequestWithContext(ctx, "POST", client.GraphQLURL(), bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.DoForOwner(strings.SplitN(batch[0].RepoName, "/", 2)[0], req)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	dataBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, time.Time{}, fmt.Errorf("Received %v from GitHub with message %v", res.Status, string(dataBytes))
+	}
+
+	var data struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+			// Path mixes field names and list indexes
+			Path []interface{} `json:"path"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		return nil, 0, time.Time{}, fmt.Errorf("[query]: %v", err)
+	}
+	for _, e := range data.Errors {
+		path := make([]string, 0, len(e.Path))
+		for _, p := range e.Path {
+			path = append(path, fmt.Sprint(p))
+		}
+		utils.LogInfo.Println("GraphQL error for:", strings.Join(path, "."), ". Error:", e.Message)
+	}
+
+	var rateLimit struct {
+		Cost      int       `json:"cost"`
+		Remaining int       `json:"remaining"`
+		ResetAt   time.Time `json:"resetAt"`
+	}
+	json.Unmarshal(data.Data["rateLimit"], &rateLimit)
+	utils.LogInfo.Println("GraphQL query for", len(batch), "repositories cost", rateLimit.Cost, "points. Remaining:", rateLimit.Remaining)
+
+	lists := make(map[uuid.UUID]*EventList, len(batch))
+	for i, repository := range batch {
+		var r *graphqlRepository
+		if err := json.Unmarshal(data.Data["r"+strconv.Itoa(i)], &r); err != nil || r == nil {
+			continue
+		}
+
+		list, complete := r.eventList(repository, cursors[repository.RepoID])
+		if !complete {
+			utils.LogInfo.Println("Too much activity to fetch with GraphQL for repository:", repository.RepoName, "falling back to the REST API")
+			continue
+		}
+		lists[repository.RepoID] = list
+	}
+
+	return lists, rateLimit.Remaining, rateLimit.ResetAt, nil
+}
+
+// eventList converts the timeline items of the repository to an event list and reports whether all of
+// the items since the cursor fit in the query
+func (r *graphqlRepository) eventList(repository services.Repository, cursor Cursor) (*EventList, bool) {
+	if r.Issues.PageInfo.HasNextPage {
+		return nil, false
+	}
+
+	list := newEventList(cursor)
+	for _, gi := range r.Issues.Nodes {
+		if gi.TimelineItems.PageInfo.HasNextPage {
+			return nil, false
+		}
+
+		issue := &events.Issue{
+			Number:    gi.Number,
+			Title:     gi.Title,
+			State:     strings.ToLower(gi.State),
+			Assignees: gi.Assignees.Nodes,
+			CreatedAt: gi.CreatedAt,
+			UpdatedAt: gi.UpdatedAt,
+		}
+		for _, l := range gi.Labels.Nodes {
+			issue.Labels = append(issue.Labels, &events.Label{Name: l.Name, Color: l.Color})
+		}
+
+		for _, item := range gi.TimelineItems.Nodes {
+			eventType, known := graphqlTimelineEvents[item.Typename]
+			if !known {
+				continue
+			}
+
+			e := events.Event{
+				Event:     eventType,
+				CreatedAt: item.CreatedAt,
+				Issue:     issue,
+			}
+			// Timeline items only have a node ID, the ID of the REST API is not exposed
+			if item.ID != "" {
+				e.ID = StableEventID(item.ID)
+			}
+			if item.Label != nil {
+				e.Label = &events.Label{Name: item.Label.Name, Color: item.Label.Color}
+			}
+			if item.Assignee != nil && item.Assignee.Login != "" {
+				e.Assignee = item.Assignee
+			}
+
+			list.addEventWithUnorderedID(e, repository)
+		}
+	}
+
+	list.sortEvents()
+
+	return list, true
+}
+
+// estimateGraphQLCost estimates the points a query for the given number of repositories costs. GitHub
+// charges one point per 100 requests needed to fill every connection, where each nested connection
+// needs one request per node of its parent.
+func estimateGraphQLCost(repositories int) int {
+	requests := repositories * (1 + 3*graphqlIssuesPerRepository)
+	return int(math.Max(1, math.Ceil(float64(requests)/100)))
+}
@@ -0,0 +1,133 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/services"
+)
+
+func TestGraphQLRepositoryEventList(t *testing.T) {
+	since := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	const issue = `"number": 7, "title": "Bug", "state": "OPEN", "createdAt": "2021-01-01T09:00:00Z", "updatedAt": "2021-01-01T11:00:00Z",
+		"assignees": {"nodes": []}, "labels": {"nodes": [{"name": "bug", "color": "d73a4a"}]}`
+
+	tests := []struct {
+		name         string
+		repository   string
+		wantComplete bool
+		wantEvents   []string
+	}{
+		{
+			name: "known timeline items are converted, oldest first",
+			repository: `{"issues": {"pageInfo": {"hasNextPage": false}, "nodes": [{` + issue + `, "timelineItems": {"pageInfo": {"hasNextPage": false}, "nodes": [
+				{"__typename": "TransferredEvent", "id": "TE_1", "createdAt": "2021-01-01T10:30:00Z"},
+				{"__typename": "LabeledEvent", "id": "LE_1", "createdAt": "2021-01-01T10:10:00Z", "label": {"name": "bug", "color": "d73a4a"}},
+				{"__typename": "ClosedEvent", "id": "CE_1", "createdAt": "2021-01-01T10:20:00Z"},
+				{"__typename": "AssignedEvent", "id": "AE_1", "createdAt": "2021-01-01T10:15:00Z"}
+			]}}]}}`,
+			wantComplete: true,
+			wantEvents:   []string{"labeled", "assigned", "closed", "transferred"},
+		},
+		{
+			name: "items before the cursor and labeled items without a label are left out",
+			repository: `{"issues": {"pageInfo": {"hasNextPage": false}, "nodes": [{` + issue + `, "timelineItems": {"pageInfo": {"hasNextPage": false}, "nodes": [
+				{"__typename": "LabeledEvent", "id": "LE_1", "createdAt": "2021-01-01T09:50:00Z", "label": {"name": "bug", "color": "d73a4a"}},
+				{"__typename": "LabeledEvent", "id": "LE_2", "createdAt": "2021-01-01T10:10:00Z"},
+				{"__typename": "UnlabeledEvent", "id": "UE_1", "createdAt": "2021-01-01T10:20:00Z", "label": {"name": "bug", "color": "d73a4a"}}
+			]}}]}}`,
+			wantComplete: true,
+			wantEvents:   []string{"unlabeled"},
+		},
+		{
+			name:       "more updated issues than fit",
+			repository: `{"issues": {"pageInfo": {"hasNextPage": true}, "nodes": []}}`,
+		},
+		{
+			name:       "more timeline items than fit",
+			repository: `{"issues": {"pageInfo": {"hasNextPage": false}, "nodes": [{` + issue + `, "timelineItems": {"pageInfo": {"hasNextPage": true}, "nodes": []}}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r graphqlRepository
+			if err := json.Unmarshal([]byte(tt.repository), &r); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+
+			list, complete := r.eventList(services.Repository{RepoName: "o/r"}, Cursor{Since: since})
+			if complete != tt.wantComplete {
+				t.Fatalf("eventList() complete = %v, want %v", complete, tt.wantComplete)
+			}
+			if !complete {
+				return
+			}
+
+			if len(list.Events) != len(tt.wantEvents) {
+				t.Fatalf("events = %d, want %v", len(list.Events), tt.wantEvents)
+			}
+			for i, e := range list.Events {
+				if e.Event != tt.wantEvents[i] {
+					t.Errorf("events[%d] = %v, want %v", i, e.Event, tt.wantEvents[i])
+				}
+				if e.ID == 0 {
+					t.Errorf("events[%d] has no ID", i)
+				}
+				if e.Issue.Number != 7 || e.Issue.State != "open" {
+					t.Errorf("events[%d] issue = %+v, want issue 7 open", i, e.Issue)
+				}
+			}
+
+			last := list.Events[len(list.Events)-1]
+			if !list.Next.Since.Equal(last.CreatedAt) || list.Next.EventID != 0 {
+				t.Errorf("Next = %+v, want since %v without event ID", list.Next, last.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestGitHubGraphQLListEventsBatchRateLimitPerHost(t *testing.T) {
+	// newServer returns a GraphQL server reporting the given remaining points, and counts its queries
+	newServer := func(remaining int, queries *int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*queries++
+			fmt.Fprintf(w, `{"data": {"r0": {"issues": {"pageInfo": {"hasNextPage": false}, "nodes": []}}, "rateLimit": {"cost": 1, "remaining": %d, "resetAt": "2021-01-01T11:00:00Z"}}}`, remaining)
+		}))
+	}
+
+	var exhaustedQueries, availableQueries int
+	exhausted := newServer(0, &exhaustedQueries)
+	defer exhausted.Close()
+	available := newServer(5000, &availableQueries)
+	defer available.Close()
+
+	repositories := []services.Repository{
+		{RepoID: uuid.New(), RepoName: "o/a", BaseURL: exhausted.URL},
+		{RepoID: uuid.New(), RepoName: "o/b", BaseURL: exhausted.URL},
+		{RepoID: uuid.New(), RepoName: "o/c", BaseURL: available.URL},
+	}
+	clients := map[string]*github.Client{
+		repositories[0].Host(): github.NewClient(exhausted.URL, []string{"a"}, 0),
+		repositories[2].Host(): github.NewClient(available.URL, []string{"a"}, 0),
+	}
+	g := NewGitHubGraphQL(clients, 1)
+
+	lists := g.ListEventsBatch(context.Background(), repositories, map[uuid.UUID]Cursor{})
+
+	if exhaustedQueries != 1 || availableQueries != 1 {
+		t.Errorf("queries = %d and %d, want 1 per host", exhaustedQueries, availableQueries)
+	}
+	for i, want := range []bool{true, false, true} {
+		if _, listed := lists[repositories[i].RepoID]; listed != want {
+			t.Errorf("repository %v listed = %v, want %v", repositories[i].RepoName, listed, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLogging("production")
+	os.Exit(m.Run())
+}
+
+func TestGitHubSourceListEventsStopsAtCursor(t *testing.T) {
+	since := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	issueEvent := func(id int64, createdAt time.Time) string {
+		return fmt.Sprintf(`{"id": %d, "event": "labeled", "created_at": %q, "issue": {"number": 1, "state": "open"}, "label": {"name": "bug"}}`, id, createdAt.Format(time.RFC3339))
+	}
+	pullRequestEvent := func(id int64, createdAt time.Time) string {
+		return fmt.Sprintf(`{"id": %d, "event": "labeled", "created_at": %q, "issue": {"number": 2, "pull_request": {}}, "label": {"name": "bug"}}`, id, createdAt.Format(time.RFC3339))
+	}
+
+	tests := []struct {
+		name string
+		// pages are served in order, each one linking to the next
+		pages         []string
+		wantRequests  int
+		wantEvents    int
+		wantTruncated bool
+	}{
+		{
+			name: "page of pull request events older than the cursor",
+			pages: []string{
+				`[` + issueEvent(5, since.Add(time.Hour)) + `]`,
+				`[` + pullRequestEvent(4, since.Add(-time.Minute)) + `, ` + pullRequestEvent(3, since.Add(-time.Hour)) + `]`,
+				`[` + issueEvent(2, since.Add(-2*time.Hour)) + `]`,
+			},
+			wantRequests: 2,
+			wantEvents:   1,
+		},
+		{
+			name: "page of pull request events newer than the cursor",
+			pages: []string{
+				`[` + pullRequestEvent(5, since.Add(time.Hour)) + `]`,
+				`[` + issueEvent(4, since.Add(time.Minute)) + `, ` + issueEvent(3, since.Add(-time.Hour)) + `]`,
+				`[` + issueEvent(2, since.Add(-2*time.Hour)) + `]`,
+			},
+			wantRequests: 2,
+			wantEvents:   1,
+		},
+		{
+			name: "history newer than the cursor",
+			pages: []string{
+				`[` + issueEvent(5, since.Add(time.Hour)) + `]`,
+				`[` + pullRequestEvent(4, since.Add(time.Minute)) + `]`,
+			},
+			wantRequests:  2,
+			wantEvents:    1,
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page := requests
+				requests++
+				if page+1 < len(tt.pages) {
+					w.Header().Set("Link", fmt.Sprintf(`<%v/repos/o/r/issues/events?page=%d>; rel="next"`, server.URL, page+2))
+				}
+				fmt.Fprint(w, tt.pages[page])
+			}))
+			defer server.Close()
+
+			repository := services.Repository{RepoName: "o/r", BaseURL: server.URL}
+			source := NewGitHubSource(map[string]*github.Client{repository.Host(): github.NewClient(server.URL, []string{"a"}, 0)}, 0)
+
+			list, err := source.ListEvents(context.Background(), repository, Cursor{Since: since, EventID: 1})
+			if err != nil {
+				t.Fatalf("ListEvents() error = %v", err)
+			}
+
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, tt.wantRequests)
+			}
+			if len(list.Events) != tt.wantEvents {
+				t.Errorf("events = %d, want %d", len(list.Events), tt.wantEvents)
+			}
+			if list.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", list.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestGitHubSourceListIssuesUpdatedSince(t *testing.T) {
+	tests := []struct {
+		name          string
+		pages         int
+		wantRequests  int
+		wantTruncated bool
+	}{
+		{name: "all pages listed", pages: 3, wantRequests: 3},
+		{name: "listing stops after the maximum pages", pages: issueListMaxPages + 5, wantRequests: issueListMaxPages, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests < tt.pages {
+					w.Header().Set("Link", fmt.Sprintf(`<%v/repos/o/r/issues?page=%d>; rel="next"`, server.URL, requests+1))
+				}
+				fmt.Fprintf(w, `[{"number": %d, "state": "open"}, {"number": %d, "state": "open", "pull_request": {}}]`, 2*requests, 2*requests+1)
+			}))
+			defer server.Close()
+
+			repository := services.Repository{RepoName: "o/r", BaseURL: server.URL}
+			source := NewGitHubSource(map[string]*github.Client{repository.Host(): github.NewClient(server.URL, []string{"a"}, 0)}, 0)
+
+			issues, truncated, err := source.ListIssuesUpdatedSince(context.Background(), repository, time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatalf("ListIssuesUpdatedSince() error = %v", err)
+			}
+
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, tt.wantRequests)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+			// The pull requests are left out
+			if len(issues) != tt.wantRequests {
+				t.Errorf("issues = %d, want %d", len(issues), tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// GitLabSource is the IssueSource of projects hosted on GitLab.com or a self-managed GitLab instance,
+// backed by the resource label events API.
+// As GitLab has no per-project issue events API, the issues updated since the cursor are listed first
+// and their label events are fetched one issue at a time.
+type GitLabSource struct {
+	token      string
+	httpClient *http.Client
+}
+
+// gitlabIssue struct defines an issue as returned by the GitLab API
+type gitlabIssue struct {
+	IID    int    `json:"iid"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Labels []struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	} `json:"labels"`
+	Assignees []struct {
+		Username string `json:"username"`
+	} `json:"assignees"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at"`
+}
+
+// gitlabLabelEvent struct defines a resource label event as returned by the GitLab API
+type gitlabLabelEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Action    string    `json:"action"`
+	Label     *struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	} `json:"label"`
+}
+
+// NewGitLabSource returns a GitLabSource. The token is sent as a personal, project or group access token.
+func NewGitLabSource(token string) *GitLabSource {
+	return &GitLabSource{
+		token:      token,
+		httpClient: &http.Client{},
+	}
+}
+
+// ListEvents returns the label events, plus a `closed` event for issues closed since the cursor, of
+// all the issues of the project updated since the cursor
+func (s *GitLabSource) ListEvents(ctx context.Context, repository services.Repository, cursor Cursor) (*EventList, error) {
+	list := newEventList(cursor)
+
+	query := url.Values{}
+	query.Set("scope", "all")
+	query.Set("with_labels_details", "true")
+	query.Set("order_by", "updated_at")
+	if !cursor.Since.IsZero() {
+		query.Set("updated_after", cursor.Since.UTC().Format(time.RFC3339))
+	}
+
+	var issues []gitlabIssue
+	err := s.paginate(ctx, s.projectURL(repository)+"/issues", query, func(dataBytes []byte) error {
+		var page []gitlabIssue
+		err := json.Unmarshal(dataBytes, &page)
+		issues = append(issues, page...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[ListEvents]: %v", err)
+	}
+
+	for _, gi := range issues {
+		issue := gi.toIssue()
+
+		var labelEvents []gitlabLabelEvent
+		err := s.paginate(ctx, s.projectURL(repository)+"/issues/"+strconv.Itoa(gi.IID)+"/resource_label_events", url.Values{}, func(dataBytes []byte) error {
+			var page []gitlabLabelEvent
+			err := json.Unmarshal(dataBytes, &page)
+			labelEvents = append(labelEvents, page...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[ListEvents]: %v", err)
+		}
+
+		for _, le := range labelEvents {
+			e := events.Event{
+				ID:        le.ID,
+				CreatedAt: le.CreatedAt,
+				Issue:     issue,
+			}
+			if le.Action == "add" {
+				e.Event = "labeled"
+			} else {
+				e.Event = "unlabeled"
+			}
+			if le.Label != nil {
+				e.Label = &events.Label{Name: le.Label.Name, Color: strings.TrimPrefix(le.Label.Color, "#")}
+			}
+
+			list.addEvent(e, repository)
+		}
+
+		if gi.ClosedAt != nil {
+			// GitLab has no close events, the one made up from the issue is known by when it got closed
+			list.addEventWithUnorderedID(events.Event{
+				ID:        StableEventID("closed:" + strconv.Itoa(gi.IID) + ":" + gi.ClosedAt.UTC().Format(time.RFC3339Nano)),
+				Event:     "closed",
+				CreatedAt: *gi.ClosedAt,
+				Issue:     issue,
+			}, repository)
+		}
+	}
+
+	list.sortEvents()
+	utils.LogInfo.Println("Fetched", len(list.Events), "issue events of", len(issues), "updated issues for repository:", repository.RepoName)
+
+	return list, nil
+}
+
+// GetIssue returns the current state of an issue of the project
+func (s *GitLabSource) GetIssue(ctx context.Context, repository services.Repository, number int) (*events.Issue, error) {
+	var gi gitlabIssue
+	dataBytes, _, err := s.get(ctx, s.projectURL(repository)+"/issues/"+strconv.Itoa(number)+"?with_labels_details=true")
+	if err != nil {
+		return nil, fmt.Errorf("[GetIssue]: %v", err)
+	}
+	if err := json.Unmarshal(dataBytes, &gi); err != nil {
+		return nil, fmt.Errorf("[GetIssue]: %v", err)
+	}
+
+	return gi.toIssue(), nil
+}
+
+func (s *GitLabSource) projectURL(repository services.Repository) string {
+	return strings.TrimSuffix(repository.BaseURL, "/") + "/api/v4/projects/" + url.PathEscape(repository.RepoName)
+}
+
+// paginate calls handle with each page of the given list endpoint, following the `X-Next-Page` header
+func (s *GitLabSource) paginate(ctx context.Context, endpoint string, query url.Values, handle func([]byte) error) error {
+	query.Set("per_page", "100")
+	page := "1"
+	for page != "" {
+		query.Set("page", page)
+		dataBytes, header, err := s.get(ctx, endpoint+"?"+query.Encode())
+		if err != nil {
+			return err
+		}
+
+		if err := handle(dataBytes); err != nil {
+			return err
+		}
+
+		page = header.Get("X-Next-Page")
+	}
+
+	return nil
+}
+
+func (s *GitLabSource) get(ctx context.Context, endpoint string) ([]byte, http.Header, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if s.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", s.token)
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	dataBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Received %v from GitLab with message %v", res.Status, string(dataBytes))
+	}
+
+	return dataBytes, res.Header, nil
+}
+
+// toIssue converts a GitLab issue to the same shape as a GitHub issue
+func (gi gitlabIssue) toIssue() *events.Issue {
+	issue := &events.Issue{
+		Number:    gi.IID,
+		Title:     gi.Title,
+		State:     gi.State,
+		CreatedAt: gi.CreatedAt,
+		UpdatedAt: gi.UpdatedAt,
+	}
+	if gi.State == "opened" {
+		issue.State = "open"
+	}
+
+	for _, l := range gi.Labels {
+		issue.Labels = append(issue.Labels, &events.Label{Name: l.Name, Color: strings.TrimPrefix(l.Color, "#")})
+	}
+	for _, a := range gi.Assignees {
+		issue.Assignees = append(issue.Assignees, &events.User{Login: a.Username})
+	}
+
+	return issue
+}
@@ -0,0 +1,92 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/services"
+)
+
+func TestGitLabSourceListEvents(t *testing.T) {
+	// Pages are keyed by the escaped path and the requested page
+	responses := map[string]string{
+		"/api/v4/projects/o%2Fr/issues?1": `[
+			{"iid": 1, "title": "Bug", "state": "opened", "labels": [{"name": "bug", "color": "#d73a4a"}], "assignees": [{"username": "octocat"}]},
+			{"iid": 2, "title": "Typo", "state": "closed", "labels": [], "assignees": [], "closed_at": "2021-01-01T10:40:00Z"}
+		]`,
+		"/api/v4/projects/o%2Fr/issues/1/resource_label_events?1": `[
+			{"id": 101, "action": "add", "created_at": "2021-01-01T09:00:00Z", "label": {"name": "bug", "color": "#d73a4a"}},
+			{"id": 102, "action": "add", "created_at": "2021-01-01T10:10:00Z", "label": {"name": "good first issue", "color": "#7057ff"}}
+		]`,
+		"/api/v4/projects/o%2Fr/issues/1/resource_label_events?2": `[
+			{"id": 103, "action": "remove", "created_at": "2021-01-01T10:20:00Z", "label": {"name": "good first issue", "color": "#7057ff"}}
+		]`,
+		"/api/v4/projects/o%2Fr/issues/2/resource_label_events?1": `[]`,
+	}
+
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("PRIVATE-TOKEN"))
+		page := r.URL.Query().Get("page")
+		response, exists := responses[r.URL.EscapedPath()+"?"+page]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, hasNext := responses[r.URL.EscapedPath()+"?2"]; hasNext && page == "1" {
+			w.Header().Set("X-Next-Page", "2")
+		}
+		fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	repository := services.Repository{RepoName: "o/r", Provider: services.ProviderGitLab, BaseURL: server.URL}
+	source := NewGitLabSource("secret")
+
+	list, err := source.ListEvents(context.Background(), repository, Cursor{Since: time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC), EventID: 101})
+	if err != nil {
+		t.Fatalf("ListEvents() error = %v", err)
+	}
+
+	want := []struct {
+		event  string
+		number int
+	}{
+		{"labeled", 1},
+		{"unlabeled", 1},
+		{"closed", 2},
+	}
+	if len(list.Events) != len(want) {
+		t.Fatalf("events = %d, want %v", len(list.Events), want)
+	}
+	for i, e := range list.Events {
+		if e.Event != want[i].event || e.Issue.Number != want[i].number {
+			t.Errorf("events[%d] = %v of issue %d, want %v of issue %d", i, e.Event, e.Issue.Number, want[i].event, want[i].number)
+		}
+	}
+
+	issue := list.Events[0].Issue
+	if issue.State != "open" || issue.Labels[0].Color != "d73a4a" || issue.Assignees[0].Login != "octocat" {
+		t.Errorf("issue = %+v, want it open with its label color trimmed and its assignee", issue)
+	}
+	if list.Events[0].Label.Color != "7057ff" {
+		t.Errorf("label color = %q, want it without the leading #", list.Events[0].Label.Color)
+	}
+	if closed := list.Events[2]; closed.ID == 0 || !closed.CreatedAt.Equal(time.Date(2021, 1, 1, 10, 40, 0, 0, time.UTC)) {
+		t.Errorf("closed event = %+v, want an ID and the close time", closed)
+	}
+	// The ID of the made up closed event is not ordered, the cursor must not rely on it
+	if list.Next.EventID != 0 {
+		t.Errorf("Next.EventID = %v, want 0", list.Next.EventID)
+	}
+
+	for _, token := range tokens {
+		if token != "secret" {
+			t.Errorf("PRIVATE-TOKEN = %q, want the configured token", token)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// Cursor marks the position up to which the issue events of a repository have been processed
+type Cursor struct {
+	// Since is the time of the most recent processed event
+	Since time.Time
+	// EventID is the ID of the most recent processed event, 0 if unknown. As events are only
+	// timestamped to the second it tells apart the events which happened in the same second.
+	EventID int64
+	// ETag and LastModified are the validators of the last response, used for conditional requests
+	ETag         string
+	LastModified string
+}
+
+// Processed reports whether the event is at or before the cursor. Events of the same second as the
+// cursor are ordered by their ID, which increases over time; without IDs they are not considered
+// processed so that none gets lost. Such events are listed again by the next run and left out by the
+// processed events store, which knows the events by their key rather than their ID.
+func (c Cursor) Processed(e events.Event) bool {
+	if !e.CreatedAt.Equal(c.Since) {
+		return e.CreatedAt.Before(c.Since)
+	}
+
+	return c.EventID != 0 && e.ID != 0 && e.ID <= c.EventID
+}
+
+// EventList is the result of listing the issue events of a repository
+type EventList struct {
+	// Events are the events which happened since the cursor, oldest first
+	Events []events.Event
+	// Next is the cursor to resume from once the events have been processed
+	Next Cursor
+	// NotModified is set if the source reported that nothing changed since the cursor
+	NotModified bool
+	// Truncated is set if not all the events since the cursor could be fetched
+	Truncated bool
+	// Stats counts the decoded and skipped events
+	Stats events.Stats
+
+	// from is the cursor the events are listed from
+	from Cursor
+	// unorderedIDs is set if the ID of some events got derived with StableEventID, in which case the IDs
+	// do not tell apart the events of the same second anymore
+	unorderedIDs bool
+}
+
+// newEventList returns an empty EventList of the events since the given cursor
+func newEventList(cursor Cursor) *EventList {
+	return &EventList{Next: cursor, from: cursor}
+}
+
+// addEvent validates the event and adds it to the list, or records why it got skipped. Events which
+// were already processed are left out.
+func (l *EventList) addEvent(e events.Event, repository services.Repository) {
+	if l.from.Processed(e) {
+		return
+	}
+
+	l.validateAndAdd(e, repository)
+}
+
+// addEventWithUnorderedID adds an event whose ID got derived with StableEventID. As such IDs do not
+// increase over time, the events of the same second as the cursor are all listed again and the ones
+// which were already processed are left to the processed events store.
+func (l *EventList) addEventWithUnorderedID(e events.Event, repository services.Repository) {
+	l.unorderedIDs = true
+	if (Cursor{Since: l.from.Since}).Processed(e) {
+		return
+	}
+
+	l.validateAndAdd(e, repository)
+}
+
+func (l *EventList) validateAndAdd(e events.Event, repository services.Repository) {
+	if err := e.Validate(); err != nil {
+		utils.LogInfo.Println("Skipping issue event for repository:", repository.RepoName, ". Reason:", err)
+		l.Stats.Add(0, []error{err})
+		return
+	}
+
+	l.Stats.Add(1, nil)
+	l.Events = append(l.Events, e)
+}
+
+// sortEvents sorts the events, oldest first, and moves the next cursor to the most recent one. The
+// events of the same second are ordered by their ID unless some IDs are unordered, in which case they
+// keep the order they were added in and the cursor does not keep the ID of its event.
+func (l *EventList) sortEvents() {
+	sort.SliceStable(l.Events, func(i, j int) bool {
+		if l.Events[i].CreatedAt.Equal(l.Events[j].CreatedAt) {
+			return !l.unorderedIDs && l.Events[i].ID < l.Events[j].ID
+		}
+
+		return l.Events[i].CreatedAt.Before(l.Events[j].CreatedAt)
+	})
+
+	if len(l.Events) > 0 {
+		l.Next.Since = l.Events[len(l.Events)-1].CreatedAt
+		l.Next.EventID = l.Events[len(l.Events)-1].ID
+		if l.unorderedIDs {
+			l.Next.EventID = 0
+		}
+	}
+}
+
+// StableEventID derives a positive event ID from an identifier which is not a number, such as the node
+// ID of a GraphQL timeline item or the ID of a webhook delivery, so that the event is recorded as
+// processed like the others. Derived IDs do not increase over time and the events must be added with
+// addEventWithUnorderedID.
+func StableEventID(key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	id := int64(h.Sum64() & math.MaxInt64)
+	if id == 0 {
+		return 1
+	}
+
+	return id
+}
+
+// IssueSource is a host of issues, such as GitHub or GitLab, which issue events are fetched from.
+// Events listed again, as the events of the same second as the cursor are, are only matched once as
+// the processed events store knows them by their issue, type, time and label or assignee.
+type IssueSource interface {
+	// ListEvents returns the issue events of the repository which happened since the cursor
+	ListEvents(ctx context.Context, repository services.Repository, cursor Cursor) (*EventList, error)
+	// GetIssue returns the current state of an issue of the repository
+	GetIssue(ctx context.Context, repository services.Repository, number int) (*events.Issue, error)
+}
+
+// IssueLister is implemented by the sources which can list all the issues of a repository updated
+// since a given time in a few requests, instead of getting them one at a time. The listing is bounded
+// and reported as truncated if some issues updated since that time may be missing from it.
+type IssueLister interface {
+	ListIssuesUpdatedSince(ctx context.Context, repository services.Repository, since time.Time) (issues map[int]*events.Issue, truncated bool, err error)
+}
+
+// registry holds the issue source of each provider
+var registry = make(map[string]IssueSource)
+
+// Register sets the issue source used for the repositories of the given provider
+func Register(provider string, source IssueSource) {
+	registry[provider] = source
+}
+
+// For returns the issue source of the given repository
+func For(repository services.Repository) (IssueSource, error) {
+	source, exists := registry[repository.Provider]
+	if !exists {
+		return nil, fmt.Errorf("No issue source registered for provider %v of repository %v", repository.Provider, repository.RepoName)
+	}
+
+	return source, nil
+}
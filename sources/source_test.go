@@ -0,0 +1,82 @@
+package sources
+
+import (
+	"testing"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+)
+
+func TestCursorProcessed(t *testing.T) {
+	since := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		cursor Cursor
+		event  events.Event
+		want   bool
+	}{
+		{
+			name:   "event before the cursor",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{ID: 200, CreatedAt: since.Add(-time.Second)},
+			want:   true,
+		},
+		{
+			name:   "event after the cursor",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{ID: 50, CreatedAt: since.Add(time.Second)},
+			want:   false,
+		},
+		{
+			name:   "cursor event",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{ID: 100, CreatedAt: since},
+			want:   true,
+		},
+		{
+			name:   "earlier event of the same second",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{ID: 99, CreatedAt: since},
+			want:   true,
+		},
+		{
+			name:   "later event of the same second",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{ID: 101, CreatedAt: since},
+			want:   false,
+		},
+		{
+			name:   "event of the same second as a cursor without ID",
+			cursor: Cursor{Since: since},
+			event:  events.Event{ID: 99, CreatedAt: since},
+			want:   false,
+		},
+		{
+			name:   "event without ID of the same second",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{CreatedAt: since},
+			want:   false,
+		},
+		{
+			name:   "event without ID before the cursor",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{CreatedAt: since.Add(-time.Second)},
+			want:   true,
+		},
+		{
+			name:   "same instant in another location",
+			cursor: Cursor{Since: since, EventID: 100},
+			event:  events.Event{ID: 100, CreatedAt: since.In(time.FixedZone("IST", 5*3600+1800))},
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cursor.Processed(tt.event); got != tt.want {
+				t.Errorf("Processed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
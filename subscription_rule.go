@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// subscriptionRule is a subscription of a user, matching the issues which carry a label for each of
+// its required patterns, a label for at least one of its any-of patterns if it has some and no label
+// for its excluded patterns. Patterns are referred to by their key in the labelMatcher.
+type subscriptionRule struct {
+	userID   uuid.UUID
+	required []string
+	anyOf    []string
+	excluded []string
+	// unassignedOnly restricts the rule to the issues nobody is assigned to
+	unassignedOnly bool
+}
+
+// newSubscriptionRule compiles the patterns of the subscription, all with its match mode, and adds
+// them to the labelMatcher. The `label` of a subscription is one of its required labels, as it is
+// the only label of the subscriptions made before rules existed.
+func newSubscriptionRule(subscription map[string]interface{}, labels *labelMatcher) (subscriptionRule, error) {
+	userIDValue, _ := subscription["userID"].(string)
+	userID, err := uuid.Parse(userIDValue)
+	if err != nil {
+		return subscriptionRule{}, fmt.Errorf("[newSubscriptionRule]: %v", err)
+	}
+	unassignedOnly, _ := subscription["unassignedOnly"].(bool)
+	rule := subscriptionRule{userID: userID, unassignedOnly: unassignedOnly}
+
+	required := stringList(subscription["requiredLabels"])
+	if label, _ := subscription["label"].(string); label != "" {
+		required = append([]string{label}, required...)
+	}
+
+	matchMode, _ := subscription["matchMode"].(string)
+	for _, list := range []struct {
+		patterns []string
+		keys     *[]string
+	}{
+		{required, &rule.required},
+		{stringList(subscription["anyLabels"]), &rule.anyOf},
+		{stringList(subscription["excludedLabels"]), &rule.excluded},
+	} {
+		for _, p := range list.patterns {
+			pattern, err := compileLabelPattern(p, matchMode)
+			if err != nil {
+				return subscriptionRule{}, fmt.Errorf("[newSubscriptionRule]: %v", err)
+			}
+			*list.keys = append(*list.keys, labels.add(pattern))
+		}
+	}
+
+	if len(rule.required) == 0 && len(rule.anyOf) == 0 {
+		return subscriptionRule{}, errors.New("[newSubscriptionRule]: a subscription needs a required or an any-of label")
+	}
+
+	return rule, nil
+}
+
+// stringList converts a JSON array of strings, ignoring anything else
+func stringList(value interface{}) []string {
+	values, _ := value.([]interface{})
+
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok && s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// patterns returns the keys of all the patterns of the rule
+func (r subscriptionRule) patterns() []string {
+	keys := make([]string, 0, len(r.required)+len(r.anyOf)+len(r.excluded))
+	keys = append(keys, r.required...)
+	keys = append(keys, r.anyOf...)
+	return append(keys, r.excluded...)
+}
+
+// satisfiedBy reports whether an issue carrying the given labels and assigned to the given number of
+// users matches the rule
+func (r subscriptionRule) satisfiedBy(labels *labelMatcher, labelNames []string, assignees int) bool {
+	if r.unassignedOnly && assignees > 0 {
+		return false
+	}
+
+	for _, key := range r.required {
+		if !labels.matchesAny(key, labelNames) {
+			return false
+		}
+	}
+
+	if len(r.anyOf) > 0 {
+		matched := false
+		for _, key := range r.anyOf {
+			if labels.matchesAny(key, labelNames) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, key := range r.excluded {
+		if labels.matchesAny(key, labelNames) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// wants reports whether the label matches a required or an any-of pattern of the rule
+func (r subscriptionRule) wants(labels *labelMatcher, labelName string) bool {
+	for _, keys := range [][]string{r.required, r.anyOf} {
+		for _, key := range keys {
+			if labels.patterns[key].matches(labelName) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// excludes reports whether the label matches an excluded pattern of the rule
+func (r subscriptionRule) excludes(labels *labelMatcher, labelName string) bool {
+	for _, key := range r.excluded {
+		if labels.patterns[key].matches(labelName) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import "testing"
+
+const testUserID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+func TestNewSubscriptionRule(t *testing.T) {
+	tests := []struct {
+		name         string
+		subscription map[string]interface{}
+		wantErr      bool
+	}{
+		{"label only", map[string]interface{}{"userID": testUserID, "label": "bug"}, false},
+		{"any-of labels only", map[string]interface{}{"userID": testUserID, "anyLabels": []interface{}{"bug", "feature"}}, false},
+		{"excluded labels only", map[string]interface{}{"userID": testUserID, "excludedLabels": []interface{}{"wontfix"}}, true},
+		{"invalid user ID", map[string]interface{}{"userID": "octocat", "label": "bug"}, true},
+		{"invalid regular expression", map[string]interface{}{"userID": testUserID, "label": "(bug", "matchMode": matchRegex}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSubscriptionRule(tt.subscription, newLabelMatcher())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newSubscriptionRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubscriptionRuleSatisfiedBy(t *testing.T) {
+	tests := []struct {
+		name         string
+		subscription map[string]interface{}
+		labels       []string
+		assignees    int
+		want         bool
+	}{
+		{
+			name:         "label",
+			subscription: map[string]interface{}{"label": "good first issue"},
+			labels:       []string{"bug", "good first issue"},
+			want:         true,
+		},
+		{
+			name:         "missing label",
+			subscription: map[string]interface{}{"label": "good first issue"},
+			labels:       []string{"bug"},
+			want:         false,
+		},
+		{
+			name:         "label and required labels",
+			subscription: map[string]interface{}{"label": "good first issue", "requiredLabels": []interface{}{"go"}},
+			labels:       []string{"good first issue", "go"},
+			want:         true,
+		},
+		{
+			name:         "missing required label",
+			subscription: map[string]interface{}{"label": "good first issue", "requiredLabels": []interface{}{"go"}},
+			labels:       []string{"good first issue", "rust"},
+			want:         false,
+		},
+		{
+			name:         "one of the any-of labels",
+			subscription: map[string]interface{}{"anyLabels": []interface{}{"bug", "feature"}},
+			labels:       []string{"feature"},
+			want:         true,
+		},
+		{
+			name:         "none of the any-of labels",
+			subscription: map[string]interface{}{"anyLabels": []interface{}{"bug", "feature"}},
+			labels:       []string{"docs"},
+			want:         false,
+		},
+		{
+			name:         "excluded label",
+			subscription: map[string]interface{}{"label": "good first issue", "excludedLabels": []interface{}{"blocked", "needs-design"}},
+			labels:       []string{"good first issue", "needs-design"},
+			want:         false,
+		},
+		{
+			name:         "excluded label matched with the match mode",
+			subscription: map[string]interface{}{"label": "good*", "excludedLabels": []interface{}{"blocked*"}, "matchMode": matchGlob},
+			labels:       []string{"Good First Issue", "Blocked by #12"},
+			want:         false,
+		},
+		{
+			name:         "required label matched with the match mode",
+			subscription: map[string]interface{}{"label": "good*", "excludedLabels": []interface{}{"blocked*"}, "matchMode": matchGlob},
+			labels:       []string{"Good First Issue"},
+			want:         true,
+		},
+		{
+			name:         "unassigned issue",
+			subscription: map[string]interface{}{"label": "help wanted", "unassignedOnly": true},
+			labels:       []string{"help wanted"},
+			want:         true,
+		},
+		{
+			name:         "assigned issue",
+			subscription: map[string]interface{}{"label": "help wanted", "unassignedOnly": true},
+			labels:       []string{"help wanted"},
+			assignees:    1,
+			want:         false,
+		},
+		{
+			name:         "assigned issue without unassignedOnly",
+			subscription: map[string]interface{}{"label": "help wanted"},
+			labels:       []string{"help wanted"},
+			assignees:    2,
+			want:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.subscription["userID"] = testUserID
+			labels := newLabelMatcher()
+			rule, err := newSubscriptionRule(tt.subscription, labels)
+			if err != nil {
+				t.Fatalf("newSubscriptionRule() error = %v", err)
+			}
+
+			if got := rule.satisfiedBy(labels, tt.labels, tt.assignees); got != tt.want {
+				t.Errorf("satisfiedBy(%v, %d) = %v, want %v", tt.labels, tt.assignees, got, tt.want)
+			}
+		})
+	}
+}
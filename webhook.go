@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/issue-notifier/notification-service/events"
+	"github.com/issue-notifier/notification-service/github"
+	"github.com/issue-notifier/notification-service/models"
+	"github.com/issue-notifier/notification-service/services"
+	"github.com/issue-notifier/notification-service/utils"
+)
+
+// maxWebhookPayloadSize is the maximum payload size GitHub sends for a webhook delivery
+const maxWebhookPayloadSize = 25 << 20
+
+// webhookActions are the `issues` webhook actions which are fed into the issue matcher
+var webhookActions = map[string]bool{
+	"labeled":     true,
+	"unlabeled":   true,
+	"closed":      true,
+	"assigned":    true,
+	"unassigned":  true,
+	"transferred": true,
+}
+
+// serveWebhooks starts the HTTP server which receives GitHub webhook deliveries until the context is
+// cancelled. The deliveries being processed are then given the grace period to finish.
+func serveWebhooks(ctx context.Context) {
+	http.HandleFunc("/webhooks/github", handleGitHubWebhook)
+	server := &http.Server{Addr: ":" + port}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownGracePeriod)*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			utils.LogError.Println("Failed to shut down the webhook server gracefully. Error:", err)
+		}
+	}()
+
+	utils.LogInfo.Println("Listening for GitHub webhooks on port:", port)
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		utils.LogError.Fatalln("Failed to start the webhook server. Error:", err)
+	}
+	<-done
+}
+
+// handleGitHubWebhook verifies and processes a single GitHub `issues` webhook delivery
+func handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		utils.LogError.Println("Failed to read payload of webhook delivery:", deliveryID, ". Error:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = github.ValidateSignature(r.Header.Get("X-Hub-Signature-256"), payload, []byte(githubWebhookSecret))
+	if err != nil {
+		utils.LogError.Println("Rejected webhook delivery:", deliveryID, ". Error:", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("X-GitHub-Event") != "issues" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	var data events.IssuesPayload
+	if err := json.Unmarshal(payload, &data); err != nil {
+		utils.LogError.Println("Failed to decode payload of webhook delivery:", deliveryID, ". Error:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if data.Repository == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Deliveries from a GitHub Enterprise Server carry its host
+	host := r.Header.Get("X-GitHub-Enterprise-Host")
+	if host == "" {
+		host = "github.com"
+	}
+
+	repoName := data.Repository.FullName
+	trackedRepository, found, err := findRepository(host, repoName)
+	if err != nil {
+		utils.LogError.Println("Failed to get repository:", repoName, "for webhook delivery:", deliveryID, ". Error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		utils.LogInfo.Println("Ignoring webhook delivery:", deliveryID, "for untracked repository:", repoName)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	deliveredAt := time.Now()
+	if webhookActions[data.Action] {
+		err = processWebhookEvent(trackedRepository, data.Event(deliveredAt), deliveryID)
+		if err != nil {
+			utils.LogError.Println("Failed to process webhook delivery:", deliveryID, "for repository:", repoName, ". Error:", err)
+
+			// The events of the repository are polled again until the next delivery, so that this one does not get lost
+			if err := models.DeleteRepositoryWebhook(trackedRepository.RepoID); err != nil {
+				utils.LogError.Println("Failed to end webhook coverage of repository:", repoName, ". Error:", err)
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		utils.LogInfo.Println("Processed", data.Action, "webhook delivery:", deliveryID, "for repository:", repoName)
+	}
+
+	// Every delivery shows the webhook of the repository works, so it is not polled anymore
+	err = models.UpsertRepositoryWebhook(trackedRepository.RepoID, deliveredAt, deliveredAt.Add(-time.Duration(webhookCoverageWindow)*time.Hour))
+	if err != nil {
+		utils.LogError.Println("Failed to record webhook delivery:", deliveryID, "for repository:", repoName, ". Error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// processWebhookEvent matches the event of a webhook delivery against the subscriptions of the repository.
+// The event is recorded as processed by its key, so neither a redelivery nor the same event fetched
+// by a poll once the webhook coverage ends gets matched again.
+func processWebhookEvent(repository services.Repository, event events.Event, deliveryID string) error {
+	if err := event.Validate(); err != nil {
+		utils.LogInfo.Println("Skipping webhook delivery:", deliveryID, ". Reason:", err)
+		return nil
+	}
+
+	matcher, err := newIssueMatcher(repository)
+	if err != nil {
+		return fmt.Errorf("[processWebhookEvent]: failed to get subscriptions: %v", err)
+	}
+
+	issueEvents, err := matcher.filterProcessed([]events.Event{event})
+	if err != nil {
+		return fmt.Errorf("[processWebhookEvent]: %v", err)
+	}
+	for _, e := range issueEvents {
+		matcher.match(e)
+	}
+
+	err = matcher.save()
+	if err != nil {
+		return fmt.Errorf("[processWebhookEvent]: failed to save notification data: %v", err)
+	}
+
+	return nil
+}
+
+// webhookCoverage returns the webhook deliveries of the repository and whether its issue events are
+// delivered by webhooks, i.e. it received a delivery within the last WEBHOOK_COVERAGE hours
+func webhookCoverage(repository services.Repository) (models.RepositoryWebhook, bool, error) {
+	if repository.Provider != services.ProviderGitHub {
+		return models.RepositoryWebhook{}, false, nil
+	}
+
+	webhook, found, err := models.GetRepositoryWebhook(repository.RepoID)
+	if err != nil {
+		return webhook, false, fmt.Errorf("[webhookCoverage]: %v", err)
+	}
+	if !found || webhook.LastDeliveryAt.Before(time.Now().Add(-time.Duration(webhookCoverageWindow)*time.Hour)) {
+		return webhook, false, nil
+	}
+
+	return webhook, true, nil
+}
+
+// eventsBefore returns the events which happened before the given time
+func eventsBefore(issueEvents []events.Event, t time.Time) []events.Event {
+	before := make([]events.Event, 0, len(issueEvents))
+	for _, e := range issueEvents {
+		if e.CreatedAt.Before(t) {
+			before = append(before, e)
+		}
+	}
+
+	return before
+}
+
+// webhookRepositoriesTTL is how long the tracked repositories are cached between webhook deliveries.
+// A repository tracked in the meantime is polled until the cache is refreshed.
+const webhookRepositoriesTTL = time.Minute
+
+// webhookRepositories caches the tracked repositories so that a delivery does not fetch them all
+var webhookRepositories struct {
+	mu           sync.Mutex
+	repositories []services.Repository
+	fetchedAt    time.Time
+}
+
+// findRepository returns the tracked GitHub repository with the given name on the given host
+func findRepository(host, repoName string) (services.Repository, bool, error) {
+	repositories, err := trackedRepositories(time.Now())
+	if err != nil {
+		return services.Repository{}, false, err
+	}
+
+	for _, repository := range repositories {
+		if repository.Provider == services.ProviderGitHub && repository.Host() == host && repository.RepoName == repoName {
+			return repository, true, nil
+		}
+	}
+
+	return services.Repository{}, false, nil
+}
+
+// trackedRepositories returns the cached tracked repositories, fetching them again once they are
+// older than webhookRepositoriesTTL. The lock is not held during the fetch.
+func trackedRepositories(now time.Time) ([]services.Repository, error) {
+	webhookRepositories.mu.Lock()
+	if now.Sub(webhookRepositories.fetchedAt) < webhookRepositoriesTTL {
+		defer webhookRepositories.mu.Unlock()
+		return webhookRepositories.repositories, nil
+	}
+	webhookRepositories.mu.Unlock()
+
+	repositories, err := services.GetAllRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	webhookRepositories.mu.Lock()
+	defer webhookRepositories.mu.Unlock()
+	webhookRepositories.repositories = repositories
+	webhookRepositories.fetchedAt = now
+
+	return repositories, nil
+}